@@ -1,17 +1,141 @@
 package main
 
 import (
+	"fmt"
+	"slices"
+
 	"github.com/PDOK/azure-storage-usage-exporter/internal/agg"
 	"github.com/PDOK/azure-storage-usage-exporter/internal/du"
 	"github.com/PDOK/azure-storage-usage-exporter/internal/metrics"
+	"github.com/PDOK/azure-storage-usage-exporter/internal/notify"
 	"github.com/creasty/defaults"
+	"github.com/prometheus/common/model"
+	"golang.org/x/exp/maps"
 )
 
 type Config struct {
+	// Include are other config files, dirs or globs (relative to this file) that are merged into this config, see readConfigFiles
+	Include configPaths                        `yaml:"include,omitempty"`
 	Azure   *du.AzureBlobInventoryReportConfig `yaml:"azure,omitempty"`
+	DuStore *du.StoreConfig                    `yaml:"duStore,omitempty"`
 	Metrics metrics.Config                     `yaml:"metrics,omitempty"`
 	Labels  agg.Labels                         `yaml:"labels"`
 	Rules   []agg.AggregationRule              `yaml:"rules"`
+	// PathLabels assign the dir names to labels by position (e.g. container, project, dataset), after trying the rules
+	PathLabels []string       `yaml:"pathLabels,omitempty"`
+	Tests      []agg.RuleTest `yaml:"tests,omitempty"`
+	// MetricFamilies are additional metrics next to the default (usage) metric, each with its own labels and rules
+	MetricFamilies []MetricFamilyConfig `yaml:"metricFamilies,omitempty"`
+	// Notifications are optional webhook notifications when conditions are met after a run
+	Notifications *notify.Config `yaml:"notifications,omitempty"`
+}
+
+type MetricFamilyConfig struct {
+	Name       string                `yaml:"name"`
+	Limit      int                   `yaml:"limit,omitempty"`
+	Labels     agg.Labels            `yaml:"labels"`
+	Rules      []agg.AggregationRule `yaml:"rules"`
+	PathLabels []string              `yaml:"pathLabels,omitempty"`
+	Tests      []agg.RuleTest        `yaml:"tests,omitempty"`
+}
+
+// GetMetricFamilies returns the default metric family (from the top level labels, rules and tests)
+// followed by the additional metric families
+func (c *Config) GetMetricFamilies() []MetricFamilyConfig {
+	defaultFamily := MetricFamilyConfig{
+		Name:       metrics.DefaultFamily,
+		Labels:     c.Labels,
+		Rules:      c.Rules,
+		PathLabels: c.PathLabels,
+		Tests:      c.Tests,
+	}
+	return append([]MetricFamilyConfig{defaultFamily}, c.MetricFamilies...)
+}
+
+// GetMetricFamily returns the metric family with the given name
+func (c *Config) GetMetricFamily(name string) (MetricFamilyConfig, error) {
+	for _, family := range c.GetMetricFamilies() {
+		if family.Name == name {
+			return family, nil
+		}
+	}
+	return MetricFamilyConfig{}, fmt.Errorf("unknown metric: %s", name)
+}
+
+// getAllRules returns the rules of every metric family
+func (c *Config) getAllRules() []agg.AggregationRule {
+	var rules []agg.AggregationRule
+	for _, familyConfig := range c.GetMetricFamilies() {
+		rules = append(rules, familyConfig.Rules...)
+	}
+	return rules
+}
+
+// loadPrefixesFiles loads the prefixes files of the rules (of every metric family),
+// which are made relative to the config file that declares them by readConfigFiles
+func (c *Config) loadPrefixesFiles() error {
+	if err := agg.LoadPrefixesFiles(c.Rules, ""); err != nil {
+		return err
+	}
+	for _, family := range c.MetricFamilies {
+		if err := agg.LoadPrefixesFiles(family.Rules, ""); err != nil {
+			return fmt.Errorf("metric %s: %w", family.Name, err)
+		}
+	}
+	return nil
+}
+
+// addPathLabelsRules appends a rule for the path labels (of every metric family) to the rules
+func (c *Config) addPathLabelsRules() error {
+	addPathLabelsRule := func(pathLabels []string, labels agg.Labels, rules *[]agg.AggregationRule) error {
+		if len(pathLabels) == 0 {
+			return nil
+		}
+		rule, err := agg.PathLabelsRule(pathLabels, labels)
+		if err != nil {
+			return err
+		}
+		*rules = append(*rules, rule)
+		return nil
+	}
+	if err := addPathLabelsRule(c.PathLabels, c.Labels, &c.Rules); err != nil {
+		return err
+	}
+	for i, family := range c.MetricFamilies {
+		if err := addPathLabelsRule(family.PathLabels, family.Labels, &c.MetricFamilies[i].Rules); err != nil {
+			return fmt.Errorf("metric %s: %w", family.Name, err)
+		}
+	}
+	return nil
+}
+
+// Validate checks the labels and rules, and runs the rule tests (of every metric family)
+func (c *Config) Validate() []agg.Issue {
+	var issues []agg.Issue
+	var names []string
+	for _, family := range c.GetMetricFamilies() {
+		if slices.Contains(names, family.Name) {
+			issues = append(issues, agg.Issue{Severity: agg.SeverityError, Message: "duplicate metric name: " + family.Name})
+		}
+		if !model.IsValidMetricName(model.LabelValue(family.Name)) || slices.Contains(metrics.ReservedNames, family.Name) {
+			issues = append(issues, agg.Issue{Severity: agg.SeverityError, Message: fmt.Sprintf("metric name %q is invalid or reserved", family.Name)})
+		}
+		names = append(names, family.Name)
+		for _, issue := range agg.Validate(family.Labels, family.Rules, family.Tests) {
+			if family.Name != metrics.DefaultFamily {
+				issue.Message = fmt.Sprintf("metric %s: %s", family.Name, issue.Message)
+			}
+			issues = append(issues, issue)
+		}
+	}
+	issues = append(issues, c.Metrics.Pricing.Validate()...)
+	issues = append(issues, c.Metrics.SizeHistogram.Validate()...)
+	labelNamesPerFamily := make(map[string][]string)
+	for _, family := range c.GetMetricFamilies() {
+		labelNamesPerFamily[family.Name] = maps.Keys(family.Labels)
+	}
+	issues = append(issues, metrics.ValidateQuotas(c.Metrics.Quotas, labelNamesPerFamily)...)
+	return append(issues, c.Notifications.Validate(names)...)
 }
 
 type unmarshalledConfig Config
@@ -0,0 +1,300 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	includeKey      = "include"
+	nameKey         = "name"
+	prefixesFileKey = "prefixesFile"
+)
+
+// configMerger merges config files (and the files they include) into a single YAML document.
+// Maps are merged recursively, lists are concatenated in file order (list items with the same name are merged),
+// and a value (e.g. a label default) that two files define differently is an error.
+type configMerger struct {
+	merged map[any]any
+	// origins are the files that defined the (non-map and non-list) values, by path
+	origins map[string]string
+	files   []configFile
+	// prefixesFiles are the (absolute) prefixes files of the rules, which aren't config files
+	// even when they're in a config dir or match a config glob
+	prefixesFiles []string
+}
+
+type configFile struct {
+	path    string
+	absPath string
+	content []byte
+	doc     map[any]any
+}
+
+// readConfigFiles reads the config file, the YAML files in a dir or the files that match a glob,
+// including the files they include, and returns the merged YAML
+func readConfigFiles(pathOrGlob string) ([]byte, error) {
+	files, err := resolveConfigFiles(pathOrGlob, "")
+	if err != nil {
+		return nil, err
+	}
+	m := &configMerger{merged: make(map[any]any), origins: make(map[string]string)}
+	for _, file := range files {
+		if err := m.read(file); err != nil {
+			return nil, err
+		}
+	}
+	for _, file := range m.files {
+		if slices.Contains(m.prefixesFiles, file.absPath) {
+			continue
+		}
+		if err := m.merge(file); err != nil {
+			return nil, err
+		}
+	}
+	return yaml.Marshal(m.merged)
+}
+
+// resolveConfigFiles returns the files (in lexical order) for a file, dir or glob, relative to baseDir
+func resolveConfigFiles(pathOrGlob string, baseDir string) ([]string, error) {
+	if !filepath.IsAbs(pathOrGlob) && baseDir != "" {
+		pathOrGlob = filepath.Join(baseDir, pathOrGlob)
+	}
+	if info, err := os.Stat(pathOrGlob); err == nil {
+		if !info.IsDir() {
+			return []string{pathOrGlob}, nil
+		}
+		pathOrGlob = filepath.Join(pathOrGlob, "*.y*ml")
+	} else if !strings.ContainsAny(pathOrGlob, "*?[") {
+		return nil, err
+	}
+	files, err := filepath.Glob(pathOrGlob)
+	if err != nil {
+		return nil, fmt.Errorf("invalid config glob %s: %w", pathOrGlob, err)
+	}
+	files = slices.DeleteFunc(files, func(file string) bool {
+		ext := filepath.Ext(file)
+		info, err := os.Stat(file)
+		return err != nil || info.IsDir() || (ext != ".yaml" && ext != ".yml")
+	})
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no config files found for %s", pathOrGlob)
+	}
+	slices.Sort(files)
+	return files, nil
+}
+
+// read reads a file and then the files it includes (each file only once), in the order they're merged
+func (m *configMerger) read(file string) error {
+	absFile, err := filepath.Abs(file)
+	if err != nil {
+		return err
+	}
+	if slices.ContainsFunc(m.files, func(f configFile) bool { return f.absPath == absFile }) {
+		return nil
+	}
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	var doc map[any]any
+	if err := yaml.Unmarshal(content, &doc); err != nil {
+		return fmt.Errorf("%s: %w", file, err)
+	}
+	var header struct {
+		Include configPaths `yaml:"include"`
+	}
+	if err := yaml.Unmarshal(content, &header); err != nil {
+		return fmt.Errorf("%s: %w", file, err)
+	}
+	delete(doc, includeKey)
+	for _, prefixesFile := range resolvePrefixesFiles(doc, filepath.Dir(file)) {
+		absPrefixesFile, err := filepath.Abs(prefixesFile)
+		if err != nil {
+			return err
+		}
+		m.prefixesFiles = append(m.prefixesFiles, absPrefixesFile)
+	}
+	m.files = append(m.files, configFile{path: file, absPath: absFile, content: content, doc: doc})
+	for _, include := range header.Include {
+		files, err := resolveConfigFiles(include, filepath.Dir(file))
+		if err != nil {
+			return fmt.Errorf("%s: %w", file, err)
+		}
+		for _, includedFile := range files {
+			if err := m.read(includedFile); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (m *configMerger) merge(file configFile) error {
+	// parsing each file on its own gives errors (e.g. unknown keys) with the right file and line
+	if err := yaml.UnmarshalStrict(file.content, new(Config)); err != nil {
+		return fmt.Errorf("%s: %w", file.path, err)
+	}
+	return m.mergeMap(m.merged, file.doc, "", file.path)
+}
+
+// configPaths are config files, dirs or globs, either a single one or a list
+type configPaths []string
+
+func (p *configPaths) UnmarshalYAML(unmarshal func(any) error) error {
+	var path string
+	if err := unmarshal(&path); err == nil {
+		*p = configPaths{path}
+		return nil
+	}
+	var paths []string
+	if err := unmarshal(&paths); err != nil {
+		return err
+	}
+	*p = paths
+	return nil
+}
+
+func (m *configMerger) mergeMap(dst, src map[any]any, path string, file string) error {
+	keys := make([]string, 0, len(src))
+	keysByString := make(map[string]any, len(src))
+	for key := range src {
+		keys = append(keys, fmt.Sprint(key))
+		keysByString[fmt.Sprint(key)] = key
+	}
+	slices.Sort(keys)
+	for _, keyString := range keys {
+		key := keysByString[keyString]
+		keyPath := strings.TrimPrefix(path+"."+keyString, ".")
+		merged, err := m.mergeValue(dst[key], src[key], keyPath, file)
+		if err != nil {
+			return err
+		}
+		dst[key] = merged
+	}
+	return nil
+}
+
+func (m *configMerger) mergeValue(dst, src any, path string, file string) (any, error) {
+	if src == nil {
+		return dst, nil
+	}
+	if dst == nil {
+		m.recordOrigins(src, path, file)
+		return src, nil
+	}
+	switch srcValue := src.(type) {
+	case map[any]any:
+		dstValue, ok := dst.(map[any]any)
+		if !ok {
+			return nil, fmt.Errorf("%s is a map in %s, but not in %s", path, file, m.originOf(path))
+		}
+		return dstValue, m.mergeMap(dstValue, srcValue, path, file)
+	case []any:
+		dstValue, ok := dst.([]any)
+		if !ok {
+			return nil, fmt.Errorf("%s is a list in %s, but not in %s", path, file, m.originOf(path))
+		}
+		return m.mergeList(dstValue, srcValue, path, file)
+	default:
+		if !reflect.DeepEqual(dst, src) {
+			return nil, fmt.Errorf("%s is defined differently in %s (%v) and %s (%v)", path, m.originOf(path), dst, file, src)
+		}
+		return dst, nil
+	}
+}
+
+// mergeList appends the items, except items with the same name as an existing item (e.g. metric families),
+// which are merged into it
+func (m *configMerger) mergeList(dst, src []any, path string, file string) ([]any, error) {
+	for _, item := range src {
+		name, named := itemName(item)
+		i := slices.IndexFunc(dst, func(existing any) bool {
+			existingName, existingNamed := itemName(existing)
+			return named && existingNamed && existingName == name
+		})
+		if i < 0 {
+			m.recordOrigins(item, itemPath(path, item, len(dst)), file)
+			dst = append(dst, item)
+			continue
+		}
+		if err := m.mergeMap(dst[i].(map[any]any), item.(map[any]any), itemPath(path, item, i), file); err != nil {
+			return nil, err
+		}
+	}
+	return dst, nil
+}
+
+func (m *configMerger) recordOrigins(value any, path string, file string) {
+	switch v := value.(type) {
+	case map[any]any:
+		for key, child := range v {
+			m.recordOrigins(child, strings.TrimPrefix(path+"."+fmt.Sprint(key), "."), file)
+		}
+	case []any:
+		for i, item := range v {
+			m.recordOrigins(item, itemPath(path, item, i), file)
+		}
+	default:
+		m.origins[path] = file
+	}
+}
+
+func (m *configMerger) originOf(path string) string {
+	if origin, ok := m.origins[path]; ok {
+		return origin
+	}
+	return "another file"
+}
+
+// itemPath identifies a list item by its name, or else its index
+func itemPath(path string, item any, i int) string {
+	if name, named := itemName(item); named {
+		return fmt.Sprintf("%s[%s]", path, name)
+	}
+	return fmt.Sprintf("%s[%d]", path, i)
+}
+
+func itemName(item any) (string, bool) {
+	itemMap, ok := item.(map[any]any)
+	if !ok {
+		return "", false
+	}
+	name, ok := itemMap[nameKey].(string)
+	return name, ok
+}
+
+// resolvePrefixesFiles makes the prefixes files of the rules (of every metric family) relative to the config file,
+// and returns them
+func resolvePrefixesFiles(doc map[any]any, dir string) []string {
+	var files []string
+	resolveRules := func(rules any) {
+		rulesList, _ := rules.([]any)
+		for _, rule := range rulesList {
+			ruleMap, _ := rule.(map[any]any)
+			file, ok := ruleMap[prefixesFileKey].(string)
+			if !ok || file == "" {
+				continue
+			}
+			if !filepath.IsAbs(file) {
+				file = filepath.Join(dir, file)
+				ruleMap[prefixesFileKey] = file
+			}
+			files = append(files, file)
+		}
+	}
+	resolveRules(doc["rules"])
+	families, _ := doc["metricFamilies"].([]any)
+	for _, family := range families {
+		if familyMap, ok := family.(map[any]any); ok {
+			resolveRules(familyMap["rules"])
+		}
+	}
+	return files
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v2"
+)
+
+func TestReadConfigFiles(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"10-main.yaml": `
+azure:
+  AzureStorageConnectionString: x
+labels:
+  tenant: other
+include: [teams/*.yaml]
+rules:
+  - pattern: ^main/
+metricFamilies:
+  - name: by_team
+    labels: {team: none}
+`,
+		"20-types.yaml": `
+labels:
+  tenant: other
+  type: other
+rules:
+  - pattern: ^(?P<type>[^/]+)/
+`,
+		"teams/a.yaml": `
+rules:
+  - prefixesFile: a-prefixes.yaml
+metricFamilies:
+  - name: by_team
+    rules:
+      - pattern: ^a/
+        labels: {team: a}
+include: ../10-main.yaml
+`,
+		"teams/a-prefixes.yaml": `a: {tenant: a}`,
+		"README.md":             `not a config file`,
+	})
+
+	for _, configPath := range []string{dir, filepath.Join(dir, "*.yaml")} {
+		configYaml, err := readConfigFiles(configPath)
+		require.Nil(t, err)
+		config := new(Config)
+		require.Nil(t, yaml.Unmarshal(configYaml, config))
+		require.Nil(t, config.loadPrefixesFiles())
+
+		assert.Equal(t, map[string]string{"tenant": "other", "type": "other"}, config.Labels)
+		require.Len(t, config.Rules, 3)
+		assert.Equal(t, "^main/", config.Rules[0].String())
+		assert.Equal(t, "prefixes (1)", config.Rules[1].String())
+		assert.Equal(t, "^(?P<type>[^/]+)/", config.Rules[2].String())
+		require.Len(t, config.MetricFamilies, 1)
+		assert.Equal(t, map[string]string{"team": "none"}, config.MetricFamilies[0].Labels)
+		assert.Len(t, config.MetricFamilies[0].Rules, 1)
+	}
+}
+
+func TestReadConfigFiles_conflict(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"a.yaml": "labels:\n  tenant: other\n",
+		"b.yaml": "labels:\n  tenant: unknown\n",
+	})
+	_, err := readConfigFiles(dir)
+	assert.EqualError(t, err, "labels.tenant is defined differently in "+filepath.Join(dir, "a.yaml")+" (other) and "+filepath.Join(dir, "b.yaml")+" (unknown)")
+
+	_, err = readConfigFiles(filepath.Join(dir, "*.yml"))
+	assert.ErrorContains(t, err, "no config files found")
+}
+
+func TestReadConfigFiles_strict(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"config.yaml": "azure:\n  blobInventoryContainer: inventory\n",
+	})
+	_, err := readConfigFiles(filepath.Join(dir, "config.yaml"))
+	assert.ErrorContains(t, err, "line 2: field blobInventoryContainer not found")
+}
+
+func TestReadConfigFiles_prefixesFileInConfigDir(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"labels.yaml": "labels:\n  tenant: other\n",
+		// the prefixes file is lexically before the rule that references it
+		"a-prefixes.yaml": "a: {tenant: a}\n",
+		"rules.yaml":      "rules:\n  - prefixesFile: a-prefixes.yaml\n",
+	})
+	configYaml, err := readConfigFiles(dir)
+	require.Nil(t, err)
+	config := new(Config)
+	require.Nil(t, yaml.UnmarshalStrict(configYaml, config))
+	require.Nil(t, config.loadPrefixesFiles())
+	require.Len(t, config.Rules, 1)
+	assert.Equal(t, "prefixes (1)", config.Rules[0].String())
+}
+
+func writeFiles(t *testing.T, dir string, contentByFile map[string]string) {
+	t.Helper()
+	for file, content := range contentByFile {
+		path := filepath.Join(dir, file)
+		require.Nil(t, os.MkdirAll(filepath.Dir(path), 0o755))
+		require.Nil(t, os.WriteFile(path, []byte(content), 0o600))
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/PDOK/azure-storage-usage-exporter/internal/agg"
+	"github.com/PDOK/azure-storage-usage-exporter/internal/du"
+	"github.com/urfave/cli/v2"
+)
+
+type jsonSchema = map[string]any
+
+var (
+	configCommand = &cli.Command{
+		Name:  "config",
+		Usage: "Config file utilities",
+		Subcommands: []*cli.Command{
+			{
+				Name:  "schema",
+				Usage: "Prints the JSON Schema of the config file, for validation and autocompletion in editors",
+				Action: func(_ *cli.Context) error {
+					encoder := json.NewEncoder(os.Stdout)
+					encoder.SetIndent("", "  ")
+					return encoder.Encode(configSchema())
+				},
+			},
+		},
+	}
+
+	// schemaOverrides are the schemas of types with custom YAML unmarshalling
+	schemaOverrides = map[reflect.Type]func() jsonSchema{
+		reflect.TypeOf(configPaths{}): func() jsonSchema {
+			return jsonSchema{"oneOf": []jsonSchema{{"type": "string"}, {"type": "array", "items": jsonSchema{"type": "string"}}}}
+		},
+		reflect.TypeOf(agg.ReGroup{}): func() jsonSchema {
+			return jsonSchema{"type": "string", "format": "regex"}
+		},
+		reflect.TypeOf(agg.Glob{}): func() jsonSchema {
+			return jsonSchema{"type": "string"}
+		},
+		reflect.TypeOf(agg.PrefixMap{}): func() jsonSchema {
+			labels := jsonSchema{"type": "object", "additionalProperties": jsonSchema{"type": "string"}}
+			return jsonSchema{"type": "object", "additionalProperties": labels}
+		},
+		reflect.TypeOf(du.ByteSize(0)): func() jsonSchema {
+			return jsonSchema{"type": []string{"string", "integer"}, "pattern": `^\s*[0-9]+(\.[0-9]+)?\s*([KMGTPkmgtp][Ii]?)?[Bb]?\s*$`}
+		},
+		reflect.TypeOf(time.Duration(0)): func() jsonSchema {
+			return jsonSchema{"type": "string", "pattern": `^([0-9]+(\.[0-9]+)?(ns|us|µs|ms|s|m|h))+$`}
+		},
+	}
+)
+
+// configSchema returns the JSON Schema of Config, derived from the YAML struct tags.
+// Unknown keys are not allowed, like when loading the config.
+func configSchema() jsonSchema {
+	schema := schemaOf(reflect.TypeOf(Config{}))
+	schema["$schema"] = "https://json-schema.org/draft/2020-12/schema"
+	schema["title"] = "Azure storage usage exporter config"
+	return schema
+}
+
+//nolint:cyclop,exhaustive // it's a switch over kinds, of which some aren't used in the config
+func schemaOf(t reflect.Type) jsonSchema {
+	if override, ok := schemaOverrides[t]; ok {
+		return override()
+	}
+	switch t.Kind() {
+	case reflect.Pointer:
+		return schemaOf(t.Elem())
+	case reflect.Struct:
+		return structSchema(t)
+	case reflect.Slice, reflect.Array:
+		return jsonSchema{"type": "array", "items": schemaOf(t.Elem())}
+	case reflect.Map:
+		return jsonSchema{"type": "object", "additionalProperties": schemaOf(t.Elem())}
+	case reflect.String:
+		return jsonSchema{"type": "string"}
+	case reflect.Bool:
+		return jsonSchema{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return jsonSchema{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return jsonSchema{"type": "number"}
+	default:
+		return jsonSchema{}
+	}
+}
+
+func structSchema(t reflect.Type) jsonSchema {
+	properties := jsonSchema{}
+	for i := range t.NumField() {
+		field := t.Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+		if !field.IsExported() || name == "-" {
+			continue
+		}
+		if name == "" {
+			name = strings.ToLower(field.Name) // like yaml.v2
+		}
+		property := schemaOf(field.Type)
+		if defaultValue, ok := field.Tag.Lookup("default"); ok {
+			property["default"] = parseDefault(defaultValue, field.Type)
+		}
+		properties[name] = property
+	}
+	return jsonSchema{"type": "object", "properties": properties, "additionalProperties": false}
+}
+
+// parseDefault converts the default (see defaults.Set) to the JSON type of the field
+func parseDefault(value string, t reflect.Type) any {
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if _, overridden := schemaOverrides[t]; !overridden {
+		switch t.Kind() { //nolint:exhaustive // other kinds have string defaults
+		case reflect.Int, reflect.Int64:
+			if i, err := strconv.ParseInt(value, 10, 64); err == nil {
+				return i
+			}
+		case reflect.Float64:
+			if f, err := strconv.ParseFloat(value, 64); err == nil {
+				return f
+			}
+		case reflect.Bool:
+			if b, err := strconv.ParseBool(value); err == nil {
+				return b
+			}
+		}
+	}
+	return value
+}
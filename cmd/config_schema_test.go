@@ -0,0 +1,25 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConfigSchema(t *testing.T) {
+	schema := configSchema()
+	assert.Equal(t, false, schema["additionalProperties"])
+	properties := schema["properties"].(jsonSchema)
+	assert.Contains(t, properties, "include")
+	assert.Contains(t, properties, "metricFamilies")
+
+	azure := properties["azure"].(jsonSchema)["properties"].(jsonSchema)
+	assert.Contains(t, azure, "AzureStorageConnectionString")
+	assert.Equal(t, int64(4), azure["threads"].(jsonSchema)["default"])
+
+	rule := properties["rules"].(jsonSchema)["items"].(jsonSchema)
+	assert.Equal(t, false, rule["additionalProperties"])
+	ruleProperties := rule["properties"].(jsonSchema)
+	assert.Equal(t, jsonSchema{"type": "string", "format": "regex"}, ruleProperties["pattern"])
+	assert.Equal(t, jsonSchema{"type": "object", "additionalProperties": jsonSchema{"type": "string"}}, ruleProperties["labels"])
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"time"
+
+	"github.com/PDOK/azure-storage-usage-exporter/internal/du"
+	"github.com/PDOK/azure-storage-usage-exporter/internal/report"
+	"github.com/urfave/cli/v2"
+)
+
+var (
+	duCommand = &cli.Command{
+		Name:      "du",
+		Usage:     "Prints the usage of the direct children of a prefix (like ncdu), using the du store or else the newest run",
+		ArgsUsage: "[prefix]",
+		Flags: []cli.Flag{
+			formatFlag,
+			&cli.IntFlag{
+				Name:  cliOptTop,
+				Usage: "Only print the given number of largest children (0 means all)",
+			},
+			rawBytesFlag,
+		},
+		Action: func(c *cli.Context) error {
+			options, err := outputOptions(c)
+			if err != nil {
+				return err
+			}
+			config, err := loadConfig(c)
+			if err != nil {
+				return err
+			}
+			duStore, err := openOrLoadDuStore(config)
+			if err != nil {
+				return err
+			}
+			defer duStore.Close()
+
+			runDate, err := duStore.GetRunDate()
+			if err != nil {
+				return err
+			}
+			prefix := strings.Trim(c.Args().First(), "/")
+			children, err := duStore.ListChildren(prefix)
+			if err != nil {
+				return err
+			}
+			return report.WriteDirListing(os.Stdout, report.NewDirListing(runDate, prefix, children), options)
+		},
+	}
+)
+
+// openOrLoadDuStore opens the configured du store,
+// or loads the newest run into an (in memory) store when there is no stored run.
+func openOrLoadDuStore(config *Config) (*du.Store, error) {
+	storeConfig := du.StoreConfig{}
+	if config.DuStore != nil {
+		storeConfig = *config.DuStore
+	}
+	duStore, err := du.NewStore(storeConfig)
+	if err != nil {
+		return nil, err
+	}
+	storedRunDate, err := duStore.GetRunDate()
+	if err == nil && storedRunDate.IsZero() {
+		err = loadNewestRun(config, duStore)
+	}
+	if err != nil {
+		_ = duStore.Close()
+		return nil, err
+	}
+	return duStore, nil
+}
+
+func loadNewestRun(config *Config, duStore *du.Store) error {
+	duReader, err := createDuReader(config)
+	if err != nil {
+		return err
+	}
+	runDate, rowsCh, errCh, err := duReader.Read(time.Time{})
+	if err != nil {
+		return err
+	}
+	return duStore.Load(runDate, rowsCh, errCh)
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"errors"
+	"os"
+
+	"github.com/PDOK/azure-storage-usage-exporter/internal/report"
+	"github.com/urfave/cli/v2"
+)
+
+var (
+	duplicatesCommand = &cli.Command{
+		Name:  "duplicates",
+		Usage: "Analyzes an inventory run for duplicate content (by Content-MD5 and size) and prints the largest duplicate sets",
+		Flags: []cli.Flag{
+			formatFlag,
+			&cli.IntFlag{
+				Name:  cliOptTop,
+				Usage: "The number of largest duplicate sets to print",
+				Value: 20,
+			},
+			rawBytesFlag,
+			runDateFlag,
+			metricFlag,
+		},
+		Action: func(c *cli.Context) error {
+			options, err := outputOptions(c)
+			if err != nil {
+				return err
+			}
+			if options.Top <= 0 {
+				return errors.New("--top must be positive")
+			}
+			config, err := loadConfig(c)
+			if err != nil {
+				return err
+			}
+			aggregator, err := createOfflineAggregator(config, c.String(cliOptMetric))
+			if err != nil {
+				return err
+			}
+			duReader, runDate, err := createAnalysisReader(c, config)
+			if err != nil {
+				return err
+			}
+			duplicates, err := duReader.ReadDuplicates(runDate, options.Top)
+			if err != nil {
+				return err
+			}
+			aggregationResults := aggregator.AggregateRows(duplicates.Rows)
+			return report.WriteDuplicates(os.Stdout, report.NewDuplicates(runDate, duplicates, aggregationResults), options)
+		},
+	}
+)
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+
+	"github.com/PDOK/azure-storage-usage-exporter/internal/agg"
+	"github.com/PDOK/azure-storage-usage-exporter/internal/du"
+	"github.com/PDOK/azure-storage-usage-exporter/internal/report"
+	"github.com/urfave/cli/v2"
+)
+
+const (
+	cliOptDeleted     = "deleted"
+	cliOptAccessTier  = "access-tier"
+	cliOptBlobType    = "blob-type"
+	cliOptContentType = "content-type"
+	cliOptSize        = "size"
+	cliOptAgeDays     = "age-days"
+	cliOptJSON        = "json"
+)
+
+var (
+	explainCommand = &cli.Command{
+		Name:      "explain",
+		Usage:     "Explains which rule matches a dir and where each label value comes from (without connecting to Azure)",
+		ArgsUsage: "<dir>",
+		Flags: []cli.Flag{
+			&cli.BoolFlag{
+				Name:  cliOptDeleted,
+				Usage: "Explain for deleted blobs",
+			},
+			&cli.StringFlag{
+				Name:  cliOptAccessTier,
+				Usage: "Explain for blobs in this access tier (e.g. Hot), for rule conditions",
+			},
+			&cli.StringFlag{
+				Name:  cliOptBlobType,
+				Usage: "Explain for blobs of this type (e.g. BlockBlob), for rule conditions",
+			},
+			&cli.StringFlag{
+				Name:  cliOptContentType,
+				Usage: "Explain for blobs with this content type, for rule conditions",
+			},
+			&cli.StringFlag{
+				Name:  cliOptSize,
+				Usage: "Explain for blobs of this size (e.g. 10MiB), for rule conditions",
+			},
+			&cli.Int64Flag{
+				Name:  cliOptAgeDays,
+				Usage: "Explain for blobs last modified this many days ago, for rule conditions",
+			},
+			&cli.BoolFlag{
+				Name:  cliOptJSON,
+				Usage: "Print as JSON",
+			},
+			metricFlag,
+		},
+		Action: func(c *cli.Context) error {
+			if c.Args().Len() != 1 {
+				return errors.New("expected exactly one dir")
+			}
+			config, err := loadConfig(c)
+			if err != nil {
+				return err
+			}
+			aggregator, err := createOfflineAggregator(config, c.String(cliOptMetric))
+			if err != nil {
+				return err
+			}
+			blob, err := exampleBlob(c)
+			if err != nil {
+				return err
+			}
+			explanation := aggregator.Explain(blob)
+			if c.Bool(cliOptJSON) {
+				encoder := json.NewEncoder(os.Stdout)
+				encoder.SetIndent("", "  ")
+				encoder.SetEscapeHTML(false)
+				return encoder.Encode(explanation)
+			}
+			return report.WriteExplanation(os.Stdout, explanation)
+		},
+	}
+)
+
+// exampleBlob returns the dir and the blob attributes to explain the rules for
+func exampleBlob(c *cli.Context) (agg.ExampleBlob, error) {
+	blob := agg.ExampleBlob{
+		Dir:         c.Args().First(),
+		Deleted:     c.Bool(cliOptDeleted),
+		AccessTier:  c.String(cliOptAccessTier),
+		BlobType:    c.String(cliOptBlobType),
+		ContentType: c.String(cliOptContentType),
+	}
+	if c.IsSet(cliOptSize) {
+		size, err := du.ParseByteSize(c.String(cliOptSize))
+		if err != nil {
+			return blob, err
+		}
+		blob.Size = &size
+	}
+	if c.IsSet(cliOptAgeDays) {
+		ageDays := c.Int64(cliOptAgeDays)
+		blob.AgeDays = &ageDays
+	}
+	return blob, nil
+}
+
+// createOfflineAggregator creates an aggregator that is only used to apply rules, so it doesn't connect to Azure
+func createOfflineAggregator(config *Config, familyName string) (*agg.Aggregator, error) {
+	familyConfig, err := config.GetMetricFamily(familyName)
+	if err != nil {
+		return nil, err
+	}
+	azureConfig := du.AzureBlobInventoryReportConfig{}
+	if config.Azure != nil {
+		azureConfig = *config.Azure
+	}
+	return agg.NewAggregator(
+		du.NewAzureBlobInventoryReportDuReader(azureConfig),
+		familyConfig.Labels,
+		familyConfig.Rules,
+	)
+}
@@ -2,12 +2,15 @@ package main
 
 import (
 	"errors"
+	"fmt"
 	"log"
 	"net/http"
 	"os"
 	"time"
 
+	"github.com/PDOK/azure-storage-usage-exporter/internal/api"
 	"github.com/PDOK/azure-storage-usage-exporter/internal/du"
+	"github.com/PDOK/azure-storage-usage-exporter/internal/notify"
 
 	"github.com/google/uuid"
 
@@ -44,9 +47,8 @@ var (
 		},
 		&cli.StringFlag{
 			Name:      cliOptConfigFile,
-			Usage:     "Config file with aggregation labels and rules",
+			Usage:     "Config file with aggregation labels and rules, or a dir or glob of config files that are merged",
 			EnvVars:   []string{strcase.ToScreamingSnake(cliOptConfigFile)},
-			Required:  true,
 			TakesFile: true,
 		},
 	}
@@ -58,23 +60,58 @@ func main() {
 	app.Name = "azure-storage-usage-exporter"
 	app.Usage = "Aggregates an Azure Blob Inventory Report and export as Prometheus metrics"
 	app.Flags = cliFlags
+	app.Commands = []*cli.Command{
+		reportCommand,
+		duCommand,
+		validateCommand,
+		explainCommand,
+		topBlobsCommand,
+		duplicatesCommand,
+		simulateLifecycleCommand,
+		suggestRulesCommand,
+		configCommand,
+	}
 	app.Action = func(c *cli.Context) error {
 		config, err := loadConfig(c)
 		if err != nil {
 			return err
 		}
-		aggregator, err := createAggregator(config)
+		if err = validateConfig(config); err != nil {
+			return err
+		}
+		var duStore *du.Store
+		if config.DuStore != nil {
+			if duStore, err = du.NewStore(*config.DuStore); err != nil {
+				return err
+			}
+			defer duStore.Close()
+		}
+		families, err := createMetricFamilies(config, duStore)
 		if err != nil {
 			return err
 		}
-		metricsUpdater := metrics.NewUpdater(aggregator, config.Metrics)
+		// the duplicates are aggregated like the usage, so they need the same tags and metadata
+		analysisReader := du.NewAzureBlobInventoryReportDuReader(*config.Azure).
+			WithAttributes(agg.GetAttributeKeys(config.getAllRules()))
+		metricsUpdater := metrics.NewUpdater(families, config.Metrics).
+			WithLargestBlobs(analysisReader).
+			WithDuplicates(analysisReader)
+		var notifier *notify.Notifier
+		if config.Notifications != nil {
+			notifier = notify.NewNotifier(*config.Notifications, families[0].Aggregator.GetStorageAccountName())
+		}
 		scheduler, err := gocron.NewScheduler()
 		if err != nil {
 			return err
 		}
 		_, err = scheduler.NewJob(
 			gocron.DurationJob(time.Hour), // blob inventory reports run daily or weekly, so checking hourly seems frequent enough
-			gocron.NewTask(metricsUpdater.UpdatePromMetrics),
+			gocron.NewTask(func() error {
+				if err := metricsUpdater.UpdatePromMetrics(); err != nil || notifier == nil {
+					return err
+				}
+				return notifier.Notify(metricsUpdater)
+			}),
 			gocron.WithName("updating metrics"),
 			gocron.WithSingletonMode(gocron.LimitModeReschedule),
 			gocron.WithStartAt(gocron.WithStartImmediately()),
@@ -87,9 +124,12 @@ func main() {
 		}
 		scheduler.Start()
 
-		http.Handle("/metrics", promhttp.Handler())
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", promhttp.Handler())
+		api.NewAPI(metricsUpdater, duStore).RegisterHandlers(mux)
 		server := &http.Server{
 			Addr:              c.String("bind-address"),
+			Handler:           mux,
 			ReadHeaderTimeout: 10 * time.Second,
 		}
 		return server.ListenAndServe()
@@ -101,29 +141,76 @@ func main() {
 	}
 }
 
-func createAggregator(config *Config) (*agg.Aggregator, error) {
+// createMetricFamilies creates an aggregator per metric family, which all share the same du reader.
+// The du reader also stores the du rows when a duStore is given.
+func createMetricFamilies(config *Config, duStore *du.Store) ([]metrics.Family, error) {
+	duReader, err := createDuReader(config)
+	if err != nil {
+		return nil, err
+	}
+	if duStore != nil {
+		duReader = du.NewStoringReader(duReader, duStore)
+	}
+	var families []metrics.Family
+	for _, familyConfig := range config.GetMetricFamilies() {
+		aggregator, err := agg.NewAggregator(duReader, familyConfig.Labels, familyConfig.Rules)
+		if err != nil {
+			return nil, err
+		}
+		families = append(families, metrics.Family{Name: familyConfig.Name, Limit: familyConfig.Limit, Aggregator: aggregator})
+	}
+	return families, nil
+}
+
+// createAggregator creates an aggregator for a single metric family
+func createAggregator(config *Config, familyName string) (*agg.Aggregator, error) {
+	familyConfig, err := config.GetMetricFamily(familyName)
+	if err != nil {
+		return nil, err
+	}
+	duReader, err := createDuReader(config)
+	if err != nil {
+		return nil, err
+	}
+	return agg.NewAggregator(duReader, familyConfig.Labels, familyConfig.Rules)
+}
+
+func createDuReader(config *Config) (du.Reader, error) {
 	if config.Azure == nil {
 		return nil, errors.New("azure config is required")
 	}
 	duReader := du.NewAzureBlobInventoryReportDuReader(*config.Azure)
+	if config.Metrics.SizeHistogram != nil {
+		duReader.WithSizeBuckets(config.Metrics.SizeHistogram.GetBuckets())
+	}
+	rules := config.getAllRules()
+	if tagKeys, metadataKeys := agg.GetAttributeKeys(rules); len(tagKeys) > 0 || len(metadataKeys) > 0 {
+		duReader.WithAttributes(tagKeys, metadataKeys)
+	}
+	duReader.WithGrouping(agg.GetGrouping(rules))
 	log.Print("testing azure connection")
 	if err := duReader.TestConnection(); err != nil {
 		return nil, err
 	}
-	return agg.NewAggregator(
-		duReader,
-		config.Labels,
-		config.Rules,
-	)
+	return duReader, nil
 }
 
 func loadConfig(c *cli.Context) (*Config, error) {
 	config := new(Config)
-	configYaml, err := os.ReadFile(c.String(cliOptConfigFile))
+	if !c.IsSet(cliOptConfigFile) {
+		return nil, fmt.Errorf("required flag %q not set", cliOptConfigFile)
+	}
+	configYaml, err := readConfigFiles(c.String(cliOptConfigFile))
 	if err != nil {
 		return nil, err
 	}
-	if err := yaml.Unmarshal(configYaml, &config); err != nil {
+	if err := yaml.UnmarshalStrict(configYaml, &config); err != nil {
+		return nil, err
+	}
+	if err := config.loadPrefixesFiles(); err != nil {
+		return nil, err
+	}
+	if err := config.addPathLabelsRules(); err != nil {
 		return nil, err
 	}
 
@@ -23,7 +23,7 @@ func TestPerf(t *testing.T) {
 		duReader := du.NewAzureBlobInventoryReportDuReader(*config.Azure)
 		aggregator, err := agg.NewAggregator(duReader, config.Labels, config.Rules)
 		require.Nil(t, err)
-		updater := metrics.NewUpdater(aggregator, config.Metrics)
+		updater := metrics.NewUpdater([]metrics.Family{{Name: metrics.DefaultFamily, Aggregator: aggregator}}, config.Metrics)
 
 		err = updater.UpdatePromMetrics()
 		require.Nil(t, err)
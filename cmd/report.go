@@ -0,0 +1,102 @@
+package main
+
+import (
+	"os"
+	"time"
+
+	"github.com/PDOK/azure-storage-usage-exporter/internal/agg"
+	"github.com/PDOK/azure-storage-usage-exporter/internal/du"
+	"github.com/PDOK/azure-storage-usage-exporter/internal/metrics"
+	"github.com/PDOK/azure-storage-usage-exporter/internal/report"
+	"github.com/urfave/cli/v2"
+)
+
+const (
+	cliOptFormat   = "format"
+	cliOptTop      = "top"
+	cliOptRunDate  = "run"
+	cliOptRawBytes = "raw-bytes"
+	cliOptMetric   = "metric"
+)
+
+var (
+	formatFlag = &cli.StringFlag{
+		Name:  cliOptFormat,
+		Usage: "Output format: table, csv, json or markdown",
+		Value: string(report.FormatTable),
+	}
+	rawBytesFlag = &cli.BoolFlag{
+		Name:  cliOptRawBytes,
+		Usage: "Print plain bytes instead of human-readable units in the table and markdown formats",
+	}
+	metricFlag = &cli.StringFlag{
+		Name:  cliOptMetric,
+		Usage: "The metric (family) of which the labels and rules are used",
+		Value: metrics.DefaultFamily,
+	}
+	runDateFlag = &cli.StringFlag{
+		Name:  cliOptRunDate,
+		Usage: "The inventory run to use, formatted as " + du.RunDateFormat + " (default: the newest run)",
+	}
+
+	reportCommand = &cli.Command{
+		Name:  "report",
+		Usage: "Aggregates an inventory run once and prints the results, largest first",
+		Flags: []cli.Flag{
+			formatFlag,
+			&cli.IntFlag{
+				Name:  cliOptTop,
+				Usage: "Only print the given number of largest results (0 means all)",
+			},
+			rawBytesFlag,
+			runDateFlag,
+			metricFlag,
+		},
+		Action: func(c *cli.Context) error {
+			options, err := outputOptions(c)
+			if err != nil {
+				return err
+			}
+			config, err := loadConfig(c)
+			if err != nil {
+				return err
+			}
+			aggregator, err := createAggregator(config, c.String(cliOptMetric))
+			if err != nil {
+				return err
+			}
+			var aggregationResults []agg.AggregationResult
+			runDate, err := parseRunDate(c)
+			if err != nil {
+				return err
+			}
+			if runDate.IsZero() {
+				aggregationResults, runDate, err = aggregator.Aggregate(time.Time{})
+			} else {
+				aggregationResults, err = aggregator.AggregateRun(runDate)
+			}
+			if err != nil {
+				return err
+			}
+			return report.Write(os.Stdout, runDate, aggregationResults, options)
+		},
+	}
+)
+
+// parseRunDate returns the zero time when no run date is given
+func parseRunDate(c *cli.Context) (time.Time, error) {
+	if c.String(cliOptRunDate) == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(du.RunDateFormat, c.String(cliOptRunDate))
+}
+
+// outputOptions reads the formatFlag, rawBytesFlag and the top flag
+func outputOptions(c *cli.Context) (report.Options, error) {
+	format, err := report.ParseFormat(c.String(cliOptFormat))
+	return report.Options{
+		Format:        format,
+		Top:           c.Int(cliOptTop),
+		HumanReadable: !c.Bool(cliOptRawBytes),
+	}, err
+}
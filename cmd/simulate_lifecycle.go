@@ -0,0 +1,67 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"os"
+
+	"github.com/PDOK/azure-storage-usage-exporter/internal/lifecycle"
+	"github.com/PDOK/azure-storage-usage-exporter/internal/report"
+	"github.com/urfave/cli/v2"
+)
+
+var (
+	simulateLifecycleCommand = &cli.Command{
+		Name:      "simulate-lifecycle",
+		Usage:     "Evaluates a lifecycle management policy (JSON) against an inventory run and prints the bytes (and estimated cost) it would move or delete per aggregation group",
+		ArgsUsage: "<policy.json>",
+		Flags: []cli.Flag{
+			formatFlag,
+			&cli.IntFlag{
+				Name:  cliOptTop,
+				Usage: "Only print the largest results (per action), 0 means all",
+			},
+			rawBytesFlag,
+			runDateFlag,
+			metricFlag,
+		},
+		Action: func(c *cli.Context) error {
+			if c.Args().Len() != 1 {
+				return errors.New("expected exactly one lifecycle policy file")
+			}
+			options, err := outputOptions(c)
+			if err != nil {
+				return err
+			}
+			policyJSON, err := os.ReadFile(c.Args().First())
+			if err != nil {
+				return err
+			}
+			policy, warnings, err := lifecycle.ParsePolicy(policyJSON)
+			for _, warning := range warnings {
+				log.Printf("warning: %s", warning)
+			}
+			if err != nil {
+				return err
+			}
+			config, err := loadConfig(c)
+			if err != nil {
+				return err
+			}
+			aggregator, err := createOfflineAggregator(config, c.String(cliOptMetric))
+			if err != nil {
+				return err
+			}
+			duReader, runDate, err := createAnalysisReader(c, config)
+			if err != nil {
+				return err
+			}
+			pricing := config.Metrics.Pricing
+			effects, err := lifecycle.Simulate(duReader, runDate, policy, aggregator, pricing)
+			if err != nil {
+				return err
+			}
+			return report.WriteLifecycleSimulation(os.Stdout, report.NewLifecycleSimulation(runDate, effects, pricing != nil), options)
+		},
+	}
+)
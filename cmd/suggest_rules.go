@@ -0,0 +1,70 @@
+package main
+
+import (
+	"errors"
+	"os"
+
+	"github.com/PDOK/azure-storage-usage-exporter/internal/agg"
+	"github.com/PDOK/azure-storage-usage-exporter/internal/report"
+	"github.com/urfave/cli/v2"
+)
+
+const (
+	cliOptMaxDepth       = "max-depth"
+	cliOptMaxCardinality = "max-cardinality"
+	cliOptMinShare       = "min-share"
+
+	largestNamesPerDepth = 3
+)
+
+var (
+	suggestRulesCommand = &cli.Command{
+		Name:  "suggest-rules",
+		Usage: "Profiles the dirs of the newest run (names per depth, bytes and cardinality) and prints starter labels and rules for the config file",
+		Flags: []cli.Flag{
+			&cli.IntFlag{
+				Name:  cliOptMaxDepth,
+				Usage: "The number of dir levels to profile",
+				Value: 5,
+			},
+			&cli.Int64Flag{
+				Name:  cliOptMaxCardinality,
+				Usage: "The max number of label combinations, 0 means the metrics limit of the config",
+			},
+			&cli.Float64Flag{
+				Name:  cliOptMinShare,
+				Usage: "The min fraction of the bytes that a label should explain (by being in dirs that deep)",
+				Value: 0.1,
+			},
+		},
+		Action: func(c *cli.Context) error {
+			if c.Int(cliOptMaxDepth) <= 0 {
+				return errors.New("--max-depth must be positive")
+			}
+			config, err := loadConfig(c)
+			if err != nil {
+				return err
+			}
+			maxCardinality := c.Int64(cliOptMaxCardinality)
+			if maxCardinality <= 0 {
+				maxCardinality = int64(config.Metrics.Limit)
+			}
+			duStore, err := openOrLoadDuStore(config)
+			if err != nil {
+				return err
+			}
+			defer duStore.Close()
+
+			runDate, err := duStore.GetRunDate()
+			if err != nil {
+				return err
+			}
+			profiles, total, err := duStore.ProfileDepths(c.Int(cliOptMaxDepth), largestNamesPerDepth)
+			if err != nil {
+				return err
+			}
+			suggestion := agg.SuggestRules(profiles, total, maxCardinality, c.Float64(cliOptMinShare))
+			return report.WriteRuleSuggestion(os.Stdout, runDate, suggestion)
+		},
+	}
+)
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"time"
+
+	"github.com/PDOK/azure-storage-usage-exporter/internal/agg"
+	"github.com/PDOK/azure-storage-usage-exporter/internal/du"
+	"github.com/PDOK/azure-storage-usage-exporter/internal/report"
+	"github.com/urfave/cli/v2"
+)
+
+var (
+	topBlobsCommand = &cli.Command{
+		Name:  "top-blobs",
+		Usage: "Queries an inventory run for the largest individual blobs and prints them",
+		Flags: []cli.Flag{
+			formatFlag,
+			&cli.IntFlag{
+				Name:  cliOptTop,
+				Usage: "The number of largest blobs to print",
+				Value: 20,
+			},
+			rawBytesFlag,
+			runDateFlag,
+		},
+		Action: func(c *cli.Context) error {
+			options, err := outputOptions(c)
+			if err != nil {
+				return err
+			}
+			if options.Top <= 0 {
+				return errors.New("--top must be positive")
+			}
+			config, err := loadConfig(c)
+			if err != nil {
+				return err
+			}
+			duReader, runDate, err := createAnalysisReader(c, config)
+			if err != nil {
+				return err
+			}
+			blobs, err := duReader.ReadLargestBlobs(runDate, options.Top)
+			if err != nil {
+				return err
+			}
+			return report.WriteTopBlobs(os.Stdout, report.NewTopBlobs(runDate, blobs), options)
+		},
+	}
+)
+
+// createAnalysisReader creates a reader for analyses other than du, and resolves the run date flag (default the newest run)
+func createAnalysisReader(c *cli.Context, config *Config) (*du.AzureBlobInventoryReportDuReader, time.Time, error) {
+	if config.Azure == nil {
+		return nil, time.Time{}, errors.New("azure config is required")
+	}
+	// the duplicates are aggregated like the usage, so they need the same tags and metadata
+	duReader := du.NewAzureBlobInventoryReportDuReader(*config.Azure).
+		WithAttributes(agg.GetAttributeKeys(config.getAllRules()))
+	runDate, err := parseRunDate(c)
+	if err != nil || !runDate.IsZero() {
+		return duReader, runDate, err
+	}
+	runs, err := duReader.ListRuns()
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	if len(runs) == 0 {
+		return nil, time.Time{}, errors.New("no inventory runs found")
+	}
+	return duReader, runs[0].Date, nil
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/PDOK/azure-storage-usage-exporter/internal/agg"
+	"github.com/urfave/cli/v2"
+)
+
+const (
+	cliOptStrict = "strict"
+)
+
+var (
+	validateCommand = &cli.Command{
+		Name:  "validate",
+		Usage: "Validates the labels and rules in the config file and runs the rule tests (without connecting to Azure)",
+		Flags: []cli.Flag{
+			&cli.BoolFlag{
+				Name:  cliOptStrict,
+				Usage: "Also fail on warnings",
+			},
+		},
+		Action: func(c *cli.Context) error {
+			config, err := loadConfig(c)
+			if err != nil {
+				return err
+			}
+			issues := config.Validate()
+			for _, issue := range issues {
+				fmt.Println(issue)
+			}
+			if agg.HasErrors(issues) || (c.Bool(cliOptStrict) && len(issues) > 0) {
+				return cli.Exit(fmt.Sprintf("config is invalid (%d issues)", len(issues)), 1)
+			}
+			for _, family := range config.GetMetricFamilies() {
+				fmt.Printf("metric %s is valid (%d rules, %d tests)\n", family.Name, len(family.Rules), len(family.Tests))
+			}
+			return nil
+		},
+	}
+)
+
+// validateConfig logs warnings and fails on errors
+func validateConfig(config *Config) error {
+	issues := config.Validate()
+	for _, issue := range issues {
+		log.Print(issue)
+	}
+	if agg.HasErrors(issues) {
+		return errors.New("config is invalid, see the errors above")
+	}
+	return nil
+}
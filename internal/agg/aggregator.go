@@ -3,8 +3,9 @@ package agg
 import (
 	"encoding/json"
 	"errors"
-	"log"
+	"fmt"
 	"slices"
+	"sync"
 	"time"
 
 	"github.com/PDOK/azure-storage-usage-exporter/internal/du"
@@ -17,6 +18,8 @@ import (
 const (
 	Deleted        = "deleted"
 	StorageAccount = "storage_account"
+
+	noRuleMatched = -1
 )
 
 type Labels = map[string]string
@@ -24,24 +27,115 @@ type Labels = map[string]string
 type AggregationRule struct {
 	// The named groups are used as labels
 	Pattern ReGroup `yaml:"pattern"`
+	// Glob is an alternative to Pattern, e.g. data/*/{tenant}/**
+	Glob Glob `yaml:"glob,omitempty"`
+	// Prefixes is an alternative to Pattern for many fixed prefixes (e.g. one per tenant), each with its own labels.
+	// The labels of the longest matching prefix are used like named groups.
+	Prefixes *PrefixMap `yaml:"prefixes,omitempty"`
+	// PrefixesFile is a YAML file with the Prefixes, relative to the config file, see LoadPrefixesFiles
+	PrefixesFile string `yaml:"prefixesFile,omitempty"`
 	// A label not found as named group is looked up in this
 	StaticLabels map[string]string `yaml:"labels"`
+	// TagLabels and MetadataLabels map labels to blob index tag and metadata keys.
+	// When the blob has the tag (or metadata), its value takes precedence over the named groups and static labels.
+	TagLabels      map[string]string `yaml:"tagLabels,omitempty"`
+	MetadataLabels map[string]string `yaml:"metadataLabels,omitempty"`
+	// Match are (optional) conditions on blob attributes, the rule only matches when they hold as well as the pattern
+	Match *RuleConditions `yaml:"match,omitempty"`
+	// Split divides the matched data over several label sets, e.g. a dataset shared by several tenants.
+	// The bytes and counts of each du row are divided proportionally to the weights of the shares.
+	Split []Share `yaml:"split,omitempty"`
+	// Drop excludes the matched data from the metric entirely (e.g. the blob inventory container itself),
+	// it's counted as excluded instead, see Coverage
+	Drop bool `yaml:"drop,omitempty"`
+}
+
+// match returns the named groups of the pattern when the rule matches the row
+func (r AggregationRule) match(row du.Row) (Labels, bool) {
+	if !r.Match.holds(row) {
+		return nil, false
+	}
+	return r.matchDir(row.Dir)
+}
+
+// matchDir matches the dir against the pattern, glob or prefixes, without the conditions
+func (r AggregationRule) matchDir(dir string) (Labels, bool) {
+	if r.Prefixes != nil {
+		_, labelsFromPrefix, found := r.Prefixes.lookup(dir)
+		return labelsFromPrefix, found
+	}
+	pattern := r.pattern()
+	if pattern.ReGroup == nil {
+		return nil, false
+	}
+	labelsFromPattern, err := pattern.Groups(dir)
+	return labelsFromPattern, err == nil
+}
+
+// pattern returns the Pattern, or the Glob as pattern. It's empty for rules with Prefixes.
+func (r AggregationRule) pattern() ReGroup {
+	if r.Glob.ReGroup.ReGroup != nil {
+		return r.Glob.ReGroup
+	}
+	return r.Pattern
+}
+
+// String describes what the rule matches on (the pattern, glob or prefixes)
+func (r AggregationRule) String() string {
+	switch {
+	case r.Prefixes != nil:
+		return fmt.Sprintf("prefixes (%d)", r.Prefixes.Len())
+	case r.Glob.ReGroup.ReGroup != nil:
+		return "glob " + r.Glob.String()
+	default:
+		return r.Pattern.String()
+	}
+}
+
+// GetAttributeKeys returns the (sorted, distinct) blob index tag and metadata keys that the rules use,
+// which the du.Reader should group by
+func GetAttributeKeys(rules []AggregationRule) (tagKeys []string, metadataKeys []string) {
+	for _, rule := range rules {
+		tagKeys = append(tagKeys, maps.Values(rule.TagLabels)...)
+		metadataKeys = append(metadataKeys, maps.Values(rule.MetadataLabels)...)
+	}
+	isEmpty := func(key string) bool { return key == "" }
+	tagKeys = slices.DeleteFunc(tagKeys, isEmpty)
+	metadataKeys = slices.DeleteFunc(metadataKeys, isEmpty)
+	slices.Sort(tagKeys)
+	slices.Sort(metadataKeys)
+	return slices.Compact(tagKeys), slices.Compact(metadataKeys)
 }
 
 type AggregationGroup struct {
-	Labels  Labels
-	Deleted bool
+	Labels  Labels `json:"labels"`
+	Deleted bool   `json:"deleted"`
 }
 
 type AggregationResult struct {
 	AggregationGroup AggregationGroup
 	StorageUsage     du.StorageUsage
+	// Count is the number of blobs
+	Count int64
+	// UsageByAccessTier splits StorageUsage and Count by access tier (empty string when unknown)
+	UsageByAccessTier map[string]TierUsage
+	// SizeBuckets is the number of blobs per size bucket, nil when the du.Reader doesn't provide size buckets
+	SizeBuckets du.SizeBuckets
+}
+
+// TierUsage is (part of) an AggregationResult within a single access tier
+type TierUsage struct {
+	StorageUsage du.StorageUsage
+	Count        int64
 }
 
 type Aggregator struct {
 	duReader           du.Reader
 	labelsWithDefaults Labels
 	rules              []AggregationRule
+
+	mu           sync.RWMutex
+	lastCoverage Coverage
 }
 
 func NewAggregator(duReader du.Reader, labelsWithDefaults Labels, rules []AggregationRule) (*Aggregator, error) {
@@ -76,43 +170,112 @@ func (a *Aggregator) GetStorageAccountName() string {
 }
 
 func (a *Aggregator) Aggregate(previousRunDate time.Time) (aggregationResults []AggregationResult, runDate time.Time, err error) {
-	log.Print("starting aggregation")
-	runDate, rowsCh, errCh, err := a.duReader.Read(previousRunDate)
+	aggregationResultsPerAggregator, runDate, err := NewMultiAggregator(a).Aggregate(previousRunDate)
+	if aggregationResultsPerAggregator != nil {
+		aggregationResults = aggregationResultsPerAggregator[0]
+	}
+	return aggregationResults, runDate, err
+}
+
+// AggregateRun is like Aggregate, but for a specific (possibly older) run instead of the newest one
+func (a *Aggregator) AggregateRun(runDate time.Time) ([]AggregationResult, error) {
+	aggregationResultsPerAggregator, err := NewMultiAggregator(a).AggregateRun(runDate)
 	if err != nil {
-		return nil, runDate, err
-	}
-	if !runDate.After(previousRunDate) {
-		return nil, runDate, nil
-	}
-
-	intermediateResults := make(map[string]du.StorageUsage)
-	i := 0
-	for rowsCh != nil && errCh != nil {
-		select {
-		case err, ok := <-errCh:
-			if !ok {
-				errCh = nil
-				continue
-			}
-			if err != nil {
-				return nil, runDate, err
-			}
-		case row, ok := <-rowsCh:
-			if !ok {
-				rowsCh = nil
-				continue
-			}
-			aggregationGroup := a.applyRulesToAggregate(row)
-			intermediateResults[marshalAggregationGroup(aggregationGroup)] += row.Bytes
-			if i%10000 == 0 {
-				log.Printf("%d disk usage rows processed so far", i)
-			}
-			i++
+		return nil, err
+	}
+	return aggregationResultsPerAggregator[0], nil
+}
+
+// AggregateRows aggregates the given rows (e.g. of another analysis than du) with the labels and rules,
+// without affecting the coverage of the last aggregation
+func (a *Aggregator) AggregateRows(rows []du.Row) []AggregationResult {
+	acc := a.newAccumulation()
+	for _, row := range rows {
+		acc.add(row)
+	}
+	return intermediateResultsToAggregationResults(acc.intermediateResults)
+}
+
+// accumulation holds the intermediate state of a single Aggregator during aggregation
+type accumulation struct {
+	aggregator          *Aggregator
+	intermediateResults map[string]*AggregationResult
+	coverage            *coverageTracker
+}
+
+func (a *Aggregator) newAccumulation() *accumulation {
+	return &accumulation{
+		aggregator:          a,
+		intermediateResults: make(map[string]*AggregationResult),
+		coverage:            newCoverageTracker(len(a.rules)),
+	}
+}
+
+func (acc *accumulation) add(row du.Row) {
+	aggregationGroup, ruleIndex := acc.aggregator.applyRulesToAggregate(row)
+	acc.coverage.add(row, ruleIndex)
+	if acc.aggregator.isDropped(ruleIndex) {
+		acc.coverage.addExcluded(row)
+		return
+	}
+	if ruleIndex != noRuleMatched && len(acc.aggregator.rules[ruleIndex].Split) > 0 {
+		shares := acc.aggregator.rules[ruleIndex].Split
+		splitGroups := acc.aggregator.splitGroups(aggregationGroup, shares)
+		for i, part := range splitRow(row, shares) {
+			acc.addToGroup(splitGroups[i], part)
+		}
+		return
+	}
+	acc.addToGroup(aggregationGroup, row)
+}
+
+func (acc *accumulation) addToGroup(aggregationGroup AggregationGroup, row du.Row) {
+	key := marshalAggregationGroup(aggregationGroup)
+	intermediateResult, exists := acc.intermediateResults[key]
+	if !exists {
+		intermediateResult = &AggregationResult{AggregationGroup: aggregationGroup, UsageByAccessTier: make(map[string]TierUsage)}
+		acc.intermediateResults[key] = intermediateResult
+	}
+	intermediateResult.StorageUsage += row.Bytes
+	intermediateResult.Count += row.Count
+	tierUsage := intermediateResult.UsageByAccessTier[row.AccessTier]
+	tierUsage.StorageUsage += row.Bytes
+	tierUsage.Count += row.Count
+	intermediateResult.UsageByAccessTier[row.AccessTier] = tierUsage
+	if row.SizeBuckets != nil {
+		if intermediateResult.SizeBuckets == nil {
+			intermediateResult.SizeBuckets = make(du.SizeBuckets, len(row.SizeBuckets))
+		}
+		for i, count := range row.SizeBuckets {
+			intermediateResult.SizeBuckets[i] += count
 		}
 	}
-	log.Printf("done aggregating blob inventory, %d du rows processed", i)
+}
+
+// isDropped tells whether the rule (by index) drops the data it matches
+func (a *Aggregator) isDropped(ruleIndex int) bool {
+	return ruleIndex != noRuleMatched && a.rules[ruleIndex].Drop
+}
 
-	return intermediateResultsToAggregationResults(intermediateResults), runDate, nil
+func (acc *accumulation) finish() []AggregationResult {
+	acc.aggregator.mu.Lock()
+	acc.aggregator.lastCoverage = acc.coverage.coverage()
+	acc.aggregator.lastCoverage.log()
+	acc.aggregator.mu.Unlock()
+
+	return intermediateResultsToAggregationResults(acc.intermediateResults)
+}
+
+// GetLastCoverage returns how much data each rule matched in the last (successful) aggregation
+func (a *Aggregator) GetLastCoverage() Coverage {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.lastCoverage
+}
+
+// Key returns a string that uniquely identifies the aggregation group
+func (g AggregationGroup) Key() string {
+	return marshalAggregationGroup(g)
 }
 
 // The key in intermediate results of Aggregator.Aggregate is a JSON representation of AggregationGroup
@@ -123,21 +286,10 @@ func marshalAggregationGroup(aggregationGroup AggregationGroup) string {
 	return string(b)
 }
 
-func unmarshalAggregationGroup(aggregationGroupJSON string) AggregationGroup {
-	aggregationGroup := new(AggregationGroup)
-	_ = json.Unmarshal([]byte(aggregationGroupJSON), aggregationGroup)
-	return *aggregationGroup
-}
-
-func intermediateResultsToAggregationResults(intermediateResults map[string]du.StorageUsage) []AggregationResult {
-	aggregationResults := make([]AggregationResult, len(intermediateResults))
-	i := 0
-	for aggregationGroup, storageUsage := range intermediateResults {
-		aggregationResults[i] = AggregationResult{
-			AggregationGroup: unmarshalAggregationGroup(aggregationGroup),
-			StorageUsage:     storageUsage,
-		}
-		i++
+func intermediateResultsToAggregationResults(intermediateResults map[string]*AggregationResult) []AggregationResult {
+	aggregationResults := make([]AggregationResult, 0, len(intermediateResults))
+	for _, intermediateResult := range intermediateResults {
+		aggregationResults = append(aggregationResults, *intermediateResult)
 	}
 
 	// sort by storageUsage desc
@@ -148,37 +300,72 @@ func intermediateResultsToAggregationResults(intermediateResults map[string]du.S
 	return aggregationResults
 }
 
-func (a *Aggregator) applyRulesToAggregate(row du.Row) AggregationGroup {
-	for _, aggregationRule := range a.rules {
-		labelsFromPattern, err := aggregationRule.Pattern.Groups(row.Dir)
-		if err != nil {
+// applyRulesToAggregate returns the aggregation group for the row and the index of the matching rule (-1 if none)
+func (a *Aggregator) applyRulesToAggregate(row du.Row) (AggregationGroup, int) {
+	for i, aggregationRule := range a.rules {
+		labelsFromPattern, ok := aggregationRule.match(row)
+		if !ok {
 			continue
 		}
 		aggregationGroup := AggregationGroup{
-			Labels: a.applyRuleDefaults(labelsFromPattern, aggregationRule),
+			Labels: a.applyRuleDefaults(row, labelsFromPattern, aggregationRule),
 		}
 		aggregationGroup.Deleted = nilBoolToBool(row.Deleted)
-		return aggregationGroup
+		return aggregationGroup, i
 	}
 	// default if no rule matches
 	return AggregationGroup{
-		Labels:  maps.Clone(a.labelsWithDefaults),
+		Labels:  a.applyRuleDefaults(row, nil, AggregationRule{}),
 		Deleted: nilBoolToBool(row.Deleted),
-	}
+	}, noRuleMatched
 }
 
-func (a *Aggregator) applyRuleDefaults(labelsFromPattern Labels, rule AggregationRule) Labels {
+func (a *Aggregator) applyRuleDefaults(row du.Row, labelsFromPattern Labels, rule AggregationRule) Labels {
 	labels := maps.Clone(a.labelsWithDefaults)
+	var templated []string
 	for label, defaultVal := range labels {
+		fromBlobOrPattern := defaultStr(
+			attributeValue(row.Tags, rule.TagLabels, label),          // first use a blob index tag
+			attributeValue(row.Metadata, rule.MetadataLabels, label), // or metadata
+			labelsFromPattern[label],                                 // then a match group
+		)
 		labels[label] = defaultStr(
-			labelsFromPattern[label], // first use a match group
+			fromBlobOrPattern,
 			rule.StaticLabels[label], // otherwise use a static label from the rule
 			defaultVal,               // fall back to the label default
 		)
+		if fromBlobOrPattern == "" && (isTemplate(rule.StaticLabels[label]) || isTemplate(defaultVal)) {
+			templated = append(templated, label)
+		}
+	}
+	if len(templated) == 0 {
+		return labels
+	}
+	// templates are rendered with the named groups and the labels that aren't templates themselves
+	data := maps.Clone(labelsFromPattern)
+	if data == nil {
+		data = make(map[string]string, len(labels))
+	}
+	for label, value := range labels {
+		if !slices.Contains(templated, label) {
+			data[label] = value
+		}
+	}
+	for _, label := range templated {
+		labels[label] = defaultStr(renderLabel(rule.StaticLabels[label], data), renderLabel(a.labelsWithDefaults[label], data))
 	}
 	return labels
 }
 
+// attributeValue returns the value of the tag or metadata key that the label is mapped to, empty when missing
+func attributeValue(attributes du.Attributes, keysByLabel map[string]string, label string) string {
+	key, ok := keysByLabel[label]
+	if !ok {
+		return ""
+	}
+	return attributes[key]
+}
+
 func defaultStr(s ...string) string {
 	for i := range s {
 		if s[i] != "" {
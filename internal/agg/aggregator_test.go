@@ -33,7 +33,7 @@ func TestAggregator_Aggregate(t *testing.T) {
 			duReader: &fakeDuReader{
 				runDate: someFixedTime,
 				rows: []du.Row{
-					{Dir: "dir1/dir2", Deleted: boolPtr(false), Bytes: 100, Count: 12},
+					{Dir: "dir1/dir2", Deleted: boolPtr(false), AccessTier: "Hot", Bytes: 100, Count: 12},
 					{Dir: "unallocatable", Deleted: boolPtr(false), Bytes: 666, Count: 666},
 					{Dir: "dir1/dir2", Deleted: boolPtr(true), Bytes: 200, Count: 30},
 					{Dir: "special/delivery", Deleted: boolPtr(false), Bytes: 321, Count: 1},
@@ -52,10 +52,10 @@ func TestAggregator_Aggregate(t *testing.T) {
 			previousRunDate: someFixedTime.Add(-24 * time.Hour),
 		},
 		wantAggregationResults: []AggregationResult{
-			{AggregationGroup: AggregationGroup{Labels: Labels{"level1": "default1", "level2": "default2", StorageAccount: "faker"}, Deleted: false}, StorageUsage: 666},
-			{AggregationGroup: AggregationGroup{Labels: Labels{"level1": "special", "level2": "sauce", StorageAccount: "faker"}, Deleted: false}, StorageUsage: 321},
-			{AggregationGroup: AggregationGroup{Labels: Labels{"level1": "dir1", "level2": "dir2", StorageAccount: "faker"}, Deleted: true}, StorageUsage: 200},
-			{AggregationGroup: AggregationGroup{Labels: Labels{"level1": "dir1", "level2": "dir2", StorageAccount: "faker"}, Deleted: false}, StorageUsage: 100},
+			{AggregationGroup: AggregationGroup{Labels: Labels{"level1": "default1", "level2": "default2", StorageAccount: "faker"}, Deleted: false}, StorageUsage: 666, Count: 666, UsageByAccessTier: map[string]TierUsage{"": {666, 666}}},
+			{AggregationGroup: AggregationGroup{Labels: Labels{"level1": "special", "level2": "sauce", StorageAccount: "faker"}, Deleted: false}, StorageUsage: 321, Count: 1, UsageByAccessTier: map[string]TierUsage{"": {321, 1}}},
+			{AggregationGroup: AggregationGroup{Labels: Labels{"level1": "dir1", "level2": "dir2", StorageAccount: "faker"}, Deleted: true}, StorageUsage: 200, Count: 30, UsageByAccessTier: map[string]TierUsage{"": {200, 30}}},
+			{AggregationGroup: AggregationGroup{Labels: Labels{"level1": "dir1", "level2": "dir2", StorageAccount: "faker"}, Deleted: false}, StorageUsage: 100, Count: 12, UsageByAccessTier: map[string]TierUsage{"Hot": {100, 12}}},
 		},
 		wantRunDate: someFixedTime,
 		wantErr:     false,
@@ -105,14 +105,162 @@ func TestAggregator_Aggregate(t *testing.T) {
 	}
 }
 
+func TestMultiAggregator_Aggregate(t *testing.T) {
+	someFixedTime, _ := time.Parse(time.DateOnly, "2024-04-20")
+	duReader := &fakeDuReader{
+		runDate: someFixedTime,
+		rows: []du.Row{
+			{Dir: "dir1/dir2", Deleted: boolPtr(false), Bytes: 100, Count: 12},
+			{Dir: "dir1/dir3", Deleted: boolPtr(false), Bytes: 200, Count: 30},
+		},
+	}
+	byLevel1, err := NewAggregator(duReader, Labels{"level1": "", StorageAccount: ""}, []AggregationRule{
+		{Pattern: NewReGroup(`^(?P<level1>[^/]+)`)},
+	})
+	require.Nil(t, err)
+	byCostCenter, err := NewAggregator(duReader, Labels{"cost_center": "unknown", StorageAccount: ""}, []AggregationRule{
+		{Pattern: NewReGroup(`^dir1/dir3`), StaticLabels: Labels{"cost_center": "finance"}},
+	})
+	require.Nil(t, err)
+
+	got, gotRunDate, err := NewMultiAggregator(byLevel1, byCostCenter).Aggregate(someFixedTime.Add(-time.Hour))
+	require.Nil(t, err)
+	require.Equal(t, someFixedTime, gotRunDate)
+	require.Equal(t, 1, duReader.reads)
+	require.Equal(t, [][]AggregationResult{{
+		{AggregationGroup: AggregationGroup{Labels: Labels{"level1": "dir1"}}, StorageUsage: 300, Count: 42, UsageByAccessTier: map[string]TierUsage{"": {300, 42}}},
+	}, {
+		{AggregationGroup: AggregationGroup{Labels: Labels{"cost_center": "finance"}}, StorageUsage: 200, Count: 30, UsageByAccessTier: map[string]TierUsage{"": {200, 30}}},
+		{AggregationGroup: AggregationGroup{Labels: Labels{"cost_center": "unknown"}}, StorageUsage: 100, Count: 12, UsageByAccessTier: map[string]TierUsage{"": {100, 12}}},
+	}}, got)
+}
+
+func TestMultiAggregator_GetLastTopDirs(t *testing.T) {
+	someFixedTime, _ := time.Parse(time.DateOnly, "2024-04-20")
+	duReader := &fakeDuReader{
+		runDate: someFixedTime,
+		rows: []du.Row{
+			{Dir: "container1/dir1/a", Deleted: boolPtr(false), Bytes: 100, Count: 10},
+			{Dir: "container1/dir1/b", Deleted: boolPtr(true), Bytes: 50, Count: 5},
+			{Dir: "container1/dir2", Deleted: boolPtr(false), Bytes: 120, Count: 1},
+			{Dir: "container2", Deleted: boolPtr(false), Bytes: 10, Count: 1},
+		},
+	}
+	aggregator, err := NewAggregator(duReader, Labels{}, nil)
+	require.Nil(t, err)
+	multiAggregator := NewMultiAggregator(aggregator).WithTopDirs(2, 2)
+	_, _, err = multiAggregator.Aggregate(someFixedTime.Add(-time.Hour))
+	require.Nil(t, err)
+	require.Equal(t, []TopDir{
+		{Dir: "container1/dir1", Bytes: 150, Count: 15},
+		{Dir: "container1/dir2", Bytes: 120, Count: 1},
+	}, multiAggregator.GetLastTopDirs())
+}
+
+func TestAggregator_GetLastCoverage(t *testing.T) {
+	someFixedTime, _ := time.Parse(time.DateOnly, "2024-04-20")
+	a, err := NewAggregator(&fakeDuReader{
+		runDate: someFixedTime,
+		rows: []du.Row{
+			{Dir: "dir1/dir2", Deleted: boolPtr(false), Bytes: 100, Count: 12},
+			{Dir: "unallocatable", Deleted: boolPtr(false), AccessTier: "Hot", Bytes: 600, Count: 600},
+			{Dir: "dir1/dir2", Deleted: boolPtr(true), Bytes: 200, Count: 30},
+			{Dir: "unallocatable", Deleted: boolPtr(false), AccessTier: "Cool", Bytes: 66, Count: 66},
+			{Dir: "more", Deleted: boolPtr(true), Bytes: 777, Count: 7},
+			{Dir: "$logs/blob", Deleted: boolPtr(false), Bytes: 50, Count: 5},
+			{Dir: "$logs/blob", Deleted: boolPtr(true), Bytes: 10, Count: 1},
+		},
+	}, Labels{"level1": "default1", "level2": "default2"}, []AggregationRule{
+		{Pattern: NewReGroup(`^(?P<level1>special)(/|$)`), StaticLabels: Labels{"level2": "sauce"}},
+		{Pattern: NewReGroup(`^\$logs/`), Drop: true},
+		{Pattern: NewReGroup(`^(?P<level1>[^/]+)/(?P<level2>[^/]+)`), StaticLabels: Labels{}},
+	})
+	require.Nil(t, err)
+	aggregationResults, _, err := a.Aggregate(time.Time{})
+	require.Nil(t, err)
+	require.Len(t, aggregationResults, 4) // without $logs
+	require.Equal(t, Coverage{
+		Rules: []RuleCoverage{
+			{Rule: "0", Bytes: 0, DuRows: 0},
+			{Rule: "1", Bytes: 60, DuRows: 2},
+			{Rule: "2", Bytes: 300, DuRows: 2},
+			{Rule: DefaultRule, Bytes: 1443, DuRows: 3},
+		},
+		Unmatched: []du.Row{ // per dir, not per du row
+			{Dir: "more", Deleted: boolPtr(true), Bytes: 777, Count: 7},
+			{Dir: "unallocatable", Deleted: boolPtr(false), Bytes: 666, Count: 666},
+		},
+		Excluded: ExcludedUsage{Bytes: 50, Count: 5, DeletedBytes: 10, DeletedCount: 1},
+	}, a.GetLastCoverage())
+
+	// aggregating other rows doesn't change the coverage
+	coverage := a.GetLastCoverage()
+	aggregationResults = a.AggregateRows([]du.Row{{Dir: "dir1/dir2/dir3", Deleted: boolPtr(false), Bytes: 5, Count: 1}})
+	require.Equal(t, []AggregationResult{{
+		AggregationGroup:  AggregationGroup{Labels: Labels{"level1": "dir1", "level2": "dir2", StorageAccount: "faker"}},
+		StorageUsage:      5,
+		Count:             1,
+		UsageByAccessTier: map[string]TierUsage{"": {5, 1}},
+	}}, aggregationResults)
+	require.Equal(t, coverage, a.GetLastCoverage())
+}
+
+func TestAggregator_Explain(t *testing.T) {
+	a, err := NewAggregator(&fakeDuReader{}, Labels{"level1": "default1", "level2": "default2", "level3": "default3"}, []AggregationRule{
+		{Pattern: NewReGroup(`^(?P<level1>special)(/|$)`), StaticLabels: Labels{"level2": "sauce"}},
+		{Pattern: NewReGroup(`^(?P<level1>[^/]+)/(?P<level2>[^/]+)`), StaticLabels: Labels{"level2": "unused", "level3": "static3"}},
+	})
+	require.Nil(t, err)
+	got := a.Explain(ExampleBlob{Dir: "dir1/dir2/dir3", Deleted: true})
+	want := Explanation{
+		Dir:     "dir1/dir2/dir3",
+		Deleted: true,
+		Trials: []RuleTrial{
+			{Index: 0, Pattern: `^(?P<level1>special)(/|$)`, Matched: false},
+			{Index: 1, Pattern: `^(?P<level1>[^/]+)/(?P<level2>[^/]+)`, Matched: true, Groups: Labels{"level1": "dir1", "level2": "dir2"}},
+		},
+		MatchedRule: 1,
+		Labels: map[string]LabelExplanation{
+			"level1":       {"dir1", LabelSourceGroup},
+			"level2":       {"dir2", LabelSourceGroup},
+			"level3":       {"static3", LabelSourceStatic},
+			StorageAccount: {"faker", LabelSourceDefault},
+		},
+		AggregationGroup: AggregationGroup{
+			Labels:  Labels{"level1": "dir1", "level2": "dir2", "level3": "static3", StorageAccount: "faker"},
+			Deleted: true,
+		},
+	}
+	require.Equal(t, want, got)
+}
+
+func TestAggregator_Explain_conditions(t *testing.T) {
+	a, err := NewAggregator(&fakeDuReader{}, Labels{"tier": "other"}, []AggregationRule{
+		{Pattern: NewReGroup(`^archive/`), Match: &RuleConditions{AccessTiers: []string{"Hot"}}, StaticLabels: Labels{"tier": "hot-archive"}},
+		{Pattern: NewReGroup(`^archive/`), StaticLabels: Labels{"tier": "archive"}},
+	})
+	require.Nil(t, err)
+
+	got := a.Explain(ExampleBlob{Dir: "archive/a", AccessTier: "Hot"})
+	require.Equal(t, 0, got.MatchedRule)
+	require.Equal(t, "hot-archive", got.AggregationGroup.Labels["tier"])
+
+	got = a.Explain(ExampleBlob{Dir: "archive/a", AccessTier: "Cool"})
+	require.Equal(t, 1, got.MatchedRule)
+	require.Equal(t, RuleTrial{Index: 0, Pattern: "^archive/", ConditionsNotMet: true}, got.Trials[0])
+	require.Equal(t, "archive", got.AggregationGroup.Labels["tier"])
+}
+
 type fakeDuReader struct {
 	runDate          time.Time
 	rows             []du.Row
 	errorImmediately bool
 	errorInChannel   bool
+	reads            int
 }
 
 func (f *fakeDuReader) Read(previousRunDate time.Time) (time.Time, <-chan du.Row, <-chan error, error) {
+	f.reads++
 	if f.errorImmediately {
 		return time.Time{}, nil, nil, errors.New("error starting to read")
 	}
@@ -134,6 +282,15 @@ func (f *fakeDuReader) Read(previousRunDate time.Time) (time.Time, <-chan du.Row
 	return f.runDate, rowsCh, errCh, nil
 }
 
+func (f *fakeDuReader) ReadRun(runDate time.Time) (<-chan du.Row, <-chan error, error) {
+	_, rowsCh, errCh, err := f.Read(runDate.Add(-time.Nanosecond))
+	return rowsCh, errCh, err
+}
+
+func (f *fakeDuReader) ListRuns() ([]du.Run, error) {
+	return []du.Run{{Date: f.runDate, Rules: []string{"all"}}}, nil
+}
+
 func (f *fakeDuReader) TestConnection() error {
 	return nil
 }
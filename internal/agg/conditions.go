@@ -0,0 +1,108 @@
+package agg
+
+import (
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/PDOK/azure-storage-usage-exporter/internal/du"
+)
+
+// RuleConditions are conditions on blob attributes, next to the pattern on the dir. All given conditions must hold.
+type RuleConditions struct {
+	// AccessTiers and BlobTypes are as in the inventory (e.g. Hot and BlockBlob), compared case-insensitively
+	AccessTiers []string `yaml:"accessTiers,omitempty"`
+	BlobTypes   []string `yaml:"blobTypes,omitempty"`
+	// ContentTypes are patterns like image/* (see path.Match)
+	ContentTypes []string `yaml:"contentTypes,omitempty"`
+	// MinSize (inclusive) and MaxSize (exclusive) bound the blob size
+	MinSize *du.ByteSize `yaml:"minSize,omitempty"`
+	MaxSize *du.ByteSize `yaml:"maxSize,omitempty"`
+	// MinAgeDays (inclusive) and MaxAgeDays (exclusive) bound the days since the blob was last modified, at the run date
+	MinAgeDays *int64 `yaml:"minAgeDays,omitempty"`
+	MaxAgeDays *int64 `yaml:"maxAgeDays,omitempty"`
+	Deleted    *bool  `yaml:"deleted,omitempty"`
+}
+
+// holds reports whether the conditions hold for all blobs of the row (nil conditions always hold).
+// Size and age conditions only hold for rows of a du.Reader that's grouped by them, see GetGrouping.
+//
+//nolint:cyclop // it's a list of independent checks
+func (c *RuleConditions) holds(row du.Row) bool {
+	if c == nil {
+		return true
+	}
+	switch {
+	case len(c.AccessTiers) > 0 && !containsFold(c.AccessTiers, row.AccessTier),
+		len(c.BlobTypes) > 0 && !containsFold(c.BlobTypes, row.BlobType),
+		len(c.ContentTypes) > 0 && !matchesAny(c.ContentTypes, row.ContentType),
+		c.MinSize != nil && (row.MinSize == nil || *row.MinSize < du.StorageUsage(*c.MinSize)),
+		c.MaxSize != nil && (row.MaxSize == nil || *row.MaxSize >= du.StorageUsage(*c.MaxSize)),
+		c.MinAgeDays != nil && (row.MinAgeDays == nil || *row.MinAgeDays < *c.MinAgeDays),
+		c.MaxAgeDays != nil && (row.MaxAgeDays == nil || *row.MaxAgeDays >= *c.MaxAgeDays),
+		c.Deleted != nil && nilBoolToBool(row.Deleted) != *c.Deleted:
+		return false
+	}
+	return true
+}
+
+func (c *RuleConditions) validate(i int) []Issue {
+	if c == nil {
+		return nil
+	}
+	var issues []Issue
+	for _, pattern := range c.ContentTypes {
+		if _, err := path.Match(pattern, ""); err != nil {
+			issues = append(issues, Issue{SeverityError, fmt.Sprintf("rule %d has invalid content type pattern %q", i, pattern)})
+		}
+	}
+	if c.MinSize != nil && c.MaxSize != nil && *c.MinSize >= *c.MaxSize {
+		issues = append(issues, Issue{SeverityError, fmt.Sprintf("rule %d never matches, minSize is not less than maxSize", i)})
+	}
+	if c.MinAgeDays != nil && c.MaxAgeDays != nil && *c.MinAgeDays >= *c.MaxAgeDays {
+		issues = append(issues, Issue{SeverityError, fmt.Sprintf("rule %d never matches, minAgeDays is not less than maxAgeDays", i)})
+	}
+	return issues
+}
+
+// GetGrouping returns the blob attributes that the du.Reader should group by, for the rule conditions to be evaluated
+func GetGrouping(rules []AggregationRule) du.Grouping {
+	var grouping du.Grouping
+	for _, rule := range rules {
+		c := rule.Match
+		if c == nil {
+			continue
+		}
+		grouping.BlobType = grouping.BlobType || len(c.BlobTypes) > 0
+		grouping.ContentType = grouping.ContentType || len(c.ContentTypes) > 0
+		for _, size := range []*du.ByteSize{c.MinSize, c.MaxSize} {
+			if size != nil {
+				grouping.SizeThresholds = append(grouping.SizeThresholds, du.StorageUsage(*size))
+			}
+		}
+		for _, ageDays := range []*int64{c.MinAgeDays, c.MaxAgeDays} {
+			if ageDays != nil {
+				grouping.AgeDaysThresholds = append(grouping.AgeDaysThresholds, *ageDays)
+			}
+		}
+	}
+	slices.Sort(grouping.SizeThresholds)
+	slices.Sort(grouping.AgeDaysThresholds)
+	grouping.SizeThresholds = slices.Compact(grouping.SizeThresholds)
+	grouping.AgeDaysThresholds = slices.Compact(grouping.AgeDaysThresholds)
+	return grouping
+}
+
+func containsFold(values []string, s string) bool {
+	return slices.ContainsFunc(values, func(value string) bool {
+		return strings.EqualFold(value, s)
+	})
+}
+
+func matchesAny(patterns []string, s string) bool {
+	return slices.ContainsFunc(patterns, func(pattern string) bool {
+		matched, _ := path.Match(pattern, s)
+		return matched
+	})
+}
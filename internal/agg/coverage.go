@@ -0,0 +1,146 @@
+package agg
+
+import (
+	"cmp"
+	"fmt"
+	"log"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/PDOK/azure-storage-usage-exporter/internal/du"
+	"golang.org/x/exp/maps"
+)
+
+const (
+	// DefaultRule identifies the (virtual) rule of rows that matched no rule
+	DefaultRule = "default"
+
+	maxUnmatchedDirs       = 100
+	maxUnmatchedDirsLogged = 10
+)
+
+// RuleCoverage is how much data a single rule matched
+type RuleCoverage struct {
+	// Rule is the index of the rule, or DefaultRule
+	Rule   string
+	Bytes  du.StorageUsage
+	DuRows int64
+}
+
+// Coverage is how much data each rule matched,
+// including the largest dirs that matched no rule at all (and got the default labels)
+// and the data that rules dropped
+type Coverage struct {
+	Rules     []RuleCoverage
+	Unmatched []du.Row
+	Excluded  ExcludedUsage
+}
+
+// ExcludedUsage is the data that was dropped by rules, see AggregationRule.Drop
+type ExcludedUsage struct {
+	Bytes        du.StorageUsage
+	Count        int64
+	DeletedBytes du.StorageUsage
+	DeletedCount int64
+}
+
+type coverageTracker struct {
+	rules []RuleCoverage
+	// unmatched sums the unmatched rows per dir, because a dir can have several rows (e.g. per access tier)
+	unmatched map[unmatchedDir]du.Row
+	excluded  ExcludedUsage
+}
+
+type unmatchedDir struct {
+	dir     string
+	deleted bool
+}
+
+func newCoverageTracker(rulesCount int) *coverageTracker {
+	rules := make([]RuleCoverage, rulesCount+1)
+	for i := range rulesCount {
+		rules[i].Rule = strconv.Itoa(i)
+	}
+	rules[rulesCount].Rule = DefaultRule
+	return &coverageTracker{rules: rules, unmatched: make(map[unmatchedDir]du.Row)}
+}
+
+func (c *coverageTracker) add(row du.Row, ruleIndex int) {
+	if ruleIndex == noRuleMatched {
+		ruleIndex = len(c.rules) - 1
+		key := unmatchedDir{row.Dir, nilBoolToBool(row.Deleted)}
+		dirRow, exists := c.unmatched[key]
+		if !exists {
+			dirRow = du.Row{Dir: row.Dir, Deleted: row.Deleted}
+		}
+		dirRow.Bytes += row.Bytes
+		dirRow.Count += row.Count
+		c.unmatched[key] = dirRow
+	}
+	c.rules[ruleIndex].Bytes += row.Bytes
+	c.rules[ruleIndex].DuRows++
+}
+
+func (c *coverageTracker) addExcluded(row du.Row) {
+	if nilBoolToBool(row.Deleted) {
+		c.excluded.DeletedBytes += row.Bytes
+		c.excluded.DeletedCount += row.Count
+	} else {
+		c.excluded.Bytes += row.Bytes
+		c.excluded.Count += row.Count
+	}
+}
+
+func (c *coverageTracker) coverage() Coverage {
+	return Coverage{
+		Rules:     c.rules,
+		Unmatched: largestRows(maps.Values(c.unmatched), maxUnmatchedDirs),
+		Excluded:  c.excluded,
+	}
+}
+
+func largestRows(rows []du.Row, n int) []du.Row {
+	// ties are sorted by dir, not deleted first, to be deterministic
+	slices.SortFunc(rows, func(a, b du.Row) int {
+		if c := cmp.Or(cmp.Compare(b.Bytes, a.Bytes), cmp.Compare(a.Dir, b.Dir)); c != 0 {
+			return c
+		}
+		aDeleted, bDeleted := nilBoolToBool(a.Deleted), nilBoolToBool(b.Deleted)
+		switch {
+		case aDeleted == bDeleted:
+			return 0
+		case aDeleted:
+			return 1
+		default:
+			return -1
+		}
+	})
+	if len(rows) > n {
+		return rows[:n]
+	}
+	return rows
+}
+
+func (c Coverage) log() {
+	if excluded := c.Excluded.Bytes + c.Excluded.DeletedBytes; excluded > 0 {
+		log.Printf("%d bytes were excluded by rules", excluded)
+	}
+	var total, unmatched du.StorageUsage
+	for _, ruleCoverage := range c.Rules {
+		total += ruleCoverage.Bytes
+		if ruleCoverage.Rule == DefaultRule {
+			unmatched = ruleCoverage.Bytes
+		}
+	}
+	if unmatched == 0 {
+		log.Print("all du rows matched a rule")
+		return
+	}
+	largest := make([]string, 0, maxUnmatchedDirsLogged)
+	for _, row := range c.Unmatched[:min(len(c.Unmatched), maxUnmatchedDirsLogged)] {
+		largest = append(largest, fmt.Sprintf("%s (%d bytes, deleted: %t)", row.Dir, row.Bytes, nilBoolToBool(row.Deleted)))
+	}
+	log.Printf("%d of %d bytes (%.1f%%) matched no rule, largest unmatched dirs: %s",
+		unmatched, total, 100*float64(unmatched)/float64(total), strings.Join(largest, ", "))
+}
@@ -0,0 +1,127 @@
+package agg
+
+import (
+	"github.com/PDOK/azure-storage-usage-exporter/internal/du"
+	"golang.org/x/exp/maps"
+)
+
+type LabelSource string
+
+const (
+	LabelSourceGroup   LabelSource = "group"
+	LabelSourceStatic  LabelSource = "static"
+	LabelSourceDefault LabelSource = "default"
+)
+
+// Explanation tells how a dir ends up in an AggregationGroup
+type Explanation struct {
+	Dir     string      `json:"dir"`
+	Deleted bool        `json:"deleted"`
+	Trials  []RuleTrial `json:"trials"`
+	// MatchedRule is the index of the rule that matched, or -1 if none matched
+	MatchedRule int `json:"matchedRule"`
+	// Excluded tells whether the matched rule drops the dir, so it isn't in the metric
+	Excluded         bool                        `json:"excluded"`
+	Labels           map[string]LabelExplanation `json:"labels"`
+	AggregationGroup AggregationGroup            `json:"aggregationGroup"`
+	// Split are the groups that the data is divided over, when the matched rule splits
+	Split []SplitShare `json:"split,omitempty"`
+}
+
+// ExampleBlob are the attributes of the blobs in a dir that the rules are explained for.
+// Attributes that aren't given don't meet rule conditions on them.
+type ExampleBlob struct {
+	Dir         string
+	Deleted     bool
+	AccessTier  string
+	BlobType    string
+	ContentType string
+	Size        *du.ByteSize
+	AgeDays     *int64
+}
+
+func (b ExampleBlob) row() du.Row {
+	row := du.Row{
+		Dir:         b.Dir,
+		Deleted:     &b.Deleted,
+		AccessTier:  b.AccessTier,
+		BlobType:    b.BlobType,
+		ContentType: b.ContentType,
+		MinAgeDays:  b.AgeDays,
+		MaxAgeDays:  b.AgeDays,
+	}
+	if b.Size != nil {
+		size := du.StorageUsage(*b.Size)
+		row.MinSize, row.MaxSize = &size, &size
+	}
+	return row
+}
+
+// RuleTrial is the outcome of trying a single AggregationRule
+type RuleTrial struct {
+	Index   int    `json:"index"`
+	Pattern string `json:"pattern"`
+	Matched bool   `json:"matched"`
+	// ConditionsNotMet tells that the dir matched, but the blob attributes didn't meet the rule conditions
+	ConditionsNotMet bool   `json:"conditionsNotMet,omitempty"`
+	Groups           Labels `json:"groups,omitempty"`
+	// Prefix is the longest matching prefix, for a rule with prefixes
+	Prefix string `json:"prefix,omitempty"`
+}
+
+// LabelExplanation tells where a label value came from
+type LabelExplanation struct {
+	Value  string      `json:"value"`
+	Source LabelSource `json:"source"`
+}
+
+// Explain tries the rules against (blobs in) a dir the same way Aggregate does, but records every step
+func (a *Aggregator) Explain(blob ExampleBlob) Explanation {
+	row := blob.row()
+	explanation := Explanation{
+		Dir:         blob.Dir,
+		Deleted:     blob.Deleted,
+		MatchedRule: noRuleMatched,
+		Labels:      make(map[string]LabelExplanation, len(a.labelsWithDefaults)),
+	}
+	explanation.AggregationGroup, _ = a.applyRulesToAggregate(row)
+	var labelsFromPattern Labels
+	var matchedRule AggregationRule
+	for i, aggregationRule := range a.rules {
+		groups, matched := aggregationRule.match(row)
+		trial := RuleTrial{
+			Index:   i,
+			Pattern: aggregationRule.String(),
+			Matched: matched,
+			Groups:  groups,
+		}
+		if !matched {
+			_, dirMatched := aggregationRule.matchDir(row.Dir)
+			trial.ConditionsNotMet = dirMatched
+		}
+		if matched && aggregationRule.Prefixes != nil {
+			trial.Prefix, _, _ = aggregationRule.Prefixes.lookup(blob.Dir)
+		}
+		explanation.Trials = append(explanation.Trials, trial)
+		if matched {
+			explanation.MatchedRule = i
+			explanation.Excluded = aggregationRule.Drop
+			explanation.Split = a.explainSplit(explanation.AggregationGroup, aggregationRule.Split)
+			labelsFromPattern = groups
+			matchedRule = aggregationRule
+			break
+		}
+	}
+	for _, label := range maps.Keys(a.labelsWithDefaults) {
+		value := explanation.AggregationGroup.Labels[label] // templates are rendered already
+		switch {
+		case labelsFromPattern[label] != "":
+			explanation.Labels[label] = LabelExplanation{value, LabelSourceGroup}
+		case matchedRule.StaticLabels[label] != "":
+			explanation.Labels[label] = LabelExplanation{value, LabelSourceStatic}
+		default:
+			explanation.Labels[label] = LabelExplanation{value, LabelSourceDefault}
+		}
+	}
+	return explanation
+}
@@ -0,0 +1,103 @@
+package agg
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/oriser/regroup"
+)
+
+var globLabelPattern = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// Glob is a simpler alternative to a (ReGroup) pattern, e.g. data/*/{tenant}/**
+//   - * matches (part of) a single dir name
+//   - ** matches any number of dir names, /** at the end also matches the dir itself
+//   - ? matches a single character (other than /)
+//   - {label} matches a single dir name and uses it as label (like a named group)
+//
+// The glob must match the whole dir.
+type Glob struct {
+	ReGroup
+	glob string
+}
+
+func NewGlob(glob string) Glob {
+	g, err := compileGlob(glob)
+	if err != nil {
+		panic(err)
+	}
+	return g
+}
+
+func compileGlob(glob string) (Glob, error) {
+	pattern, err := globToRegex(glob)
+	if err != nil {
+		return Glob{}, err
+	}
+	reGroup, err := regroup.Compile(pattern)
+	if err != nil {
+		return Glob{}, err
+	}
+	return Glob{ReGroup: ReGroup{ReGroup: reGroup, original: pattern}, glob: glob}, nil
+}
+
+// globToRegex translates a glob to an (anchored) regex with named groups
+func globToRegex(glob string) (string, error) {
+	var sb strings.Builder
+	sb.WriteString("^")
+	for i := 0; i < len(glob); i++ {
+		rest := glob[i:]
+		switch {
+		case rest == "/**":
+			sb.WriteString("(?:/.*)?")
+			i += len(rest) - 1
+		case strings.HasPrefix(rest, "**/"):
+			sb.WriteString("(?:.*/)?")
+			i += 2
+		case strings.HasPrefix(rest, "**"):
+			sb.WriteString(".*")
+			i++
+		case rest[0] == '*':
+			sb.WriteString("[^/]*")
+		case rest[0] == '?':
+			sb.WriteString("[^/]")
+		case rest[0] == '{':
+			end := strings.IndexByte(rest, '}')
+			if end < 0 {
+				return "", fmt.Errorf("glob %q has an unclosed {", glob)
+			}
+			label := rest[1:end]
+			if !globLabelPattern.MatchString(label) {
+				return "", fmt.Errorf("glob %q has an invalid label {%s}", glob, label)
+			}
+			sb.WriteString("(?P<" + label + ">[^/]+)")
+			i += end
+		default:
+			sb.WriteString(regexp.QuoteMeta(rest[:1]))
+		}
+	}
+	sb.WriteString("$")
+	return sb.String(), nil
+}
+
+func (g *Glob) UnmarshalYAML(unmarshal func(any) error) error {
+	var glob string
+	if err := unmarshal(&glob); err != nil {
+		return err
+	}
+	compiled, err := compileGlob(glob)
+	if err != nil {
+		return err
+	}
+	*g = compiled
+	return nil
+}
+
+func (g Glob) MarshalYAML() (interface{}, error) {
+	return g.glob, nil
+}
+
+func (g Glob) String() string {
+	return g.glob
+}
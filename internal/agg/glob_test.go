@@ -0,0 +1,71 @@
+package agg
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGlob(t *testing.T) {
+	tests := []struct {
+		glob      string
+		wantRegex string
+		matches   map[string]Labels
+		noMatches []string
+	}{
+		{
+			glob:      "data/*/{tenant}/**",
+			wantRegex: `^data/[^/]*/(?P<tenant>[^/]+)(?:/.*)?$`,
+			matches: map[string]Labels{
+				"data/x/acme":       {"tenant": "acme"},
+				"data/x/acme/y/z":   {"tenant": "acme"},
+				"data/x.y/acme/raw": {"tenant": "acme"},
+			},
+			noMatches: []string{"data/x", "data/acme", "other/x/acme"},
+		},
+		{
+			glob:      "**/{dataset}.zarr",
+			wantRegex: `^(?:.*/)?(?P<dataset>[^/]+)\.zarr$`,
+			matches: map[string]Labels{
+				"foo.zarr":       {"dataset": "foo"},
+				"a/b/foo.zarr":   {"dataset": "foo"},
+				"a/bar.baz.zarr": {"dataset": "bar.baz"},
+			},
+			noMatches: []string{"a/foo_zarr", "foo.zarr/chunks"},
+		},
+		{
+			glob:      "logs-202?/**",
+			wantRegex: `^logs-202[^/](?:/.*)?$`,
+			matches:   map[string]Labels{"logs-2024": {}, "logs-2024/01": {}},
+			noMatches: []string{"logs-20245", "logs-202/x"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.glob, func(t *testing.T) {
+			glob, err := compileGlob(tt.glob)
+			require.Nil(t, err)
+			assert.Equal(t, tt.wantRegex, glob.ReGroup.String())
+			assert.Equal(t, tt.glob, glob.String())
+			for dir, wantLabels := range tt.matches {
+				groups, err := glob.Groups(dir)
+				if assert.Nil(t, err, dir) {
+					for label, want := range wantLabels {
+						assert.Equal(t, want, groups[label], dir)
+					}
+				}
+			}
+			for _, dir := range tt.noMatches {
+				_, err := glob.Groups(dir)
+				assert.NotNil(t, err, dir)
+			}
+		})
+	}
+}
+
+func TestGlob_invalid(t *testing.T) {
+	for _, glob := range []string{"data/{tenant", "data/{ten-ant}", "data/{}"} {
+		_, err := compileGlob(glob)
+		assert.NotNil(t, err, glob)
+	}
+}
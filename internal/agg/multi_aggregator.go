@@ -0,0 +1,123 @@
+package agg
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/PDOK/azure-storage-usage-exporter/internal/du"
+)
+
+// MultiAggregator runs several Aggregator s (with their own labels and rules) over the same du rows,
+// so the inventory only has to be read once
+type MultiAggregator struct {
+	duReader    du.Reader
+	aggregators []*Aggregator
+
+	topDirsCount int // 0 means top dirs are not tracked
+	topDirsDepth int
+	mu           sync.RWMutex
+	lastTopDirs  []TopDir
+}
+
+// NewMultiAggregator creates a MultiAggregator, which reads du rows using the du.Reader of the first aggregator
+func NewMultiAggregator(aggregators ...*Aggregator) *MultiAggregator {
+	return &MultiAggregator{
+		duReader:    aggregators[0].duReader,
+		aggregators: aggregators,
+	}
+}
+
+// WithTopDirs makes the MultiAggregator also track the n largest dirs, truncated to depth (0 means not truncated)
+func (m *MultiAggregator) WithTopDirs(n, depth int) *MultiAggregator {
+	m.topDirsCount = n
+	m.topDirsDepth = depth
+	return m
+}
+
+// GetLastTopDirs returns the largest dirs of the last (successful) aggregation, see WithTopDirs
+func (m *MultiAggregator) GetLastTopDirs() []TopDir {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.lastTopDirs
+}
+
+// Aggregate is like Aggregator.Aggregate, the results are in the same order as the aggregators
+func (m *MultiAggregator) Aggregate(previousRunDate time.Time) (aggregationResultsPerAggregator [][]AggregationResult, runDate time.Time, err error) {
+	log.Print("starting aggregation")
+	runDate, rowsCh, errCh, err := m.duReader.Read(previousRunDate)
+	if err != nil {
+		return nil, runDate, err
+	}
+	if !runDate.After(previousRunDate) {
+		return nil, runDate, nil
+	}
+	aggregationResultsPerAggregator, err = m.aggregate(rowsCh, errCh)
+	return aggregationResultsPerAggregator, runDate, err
+}
+
+// AggregateRun is like Aggregator.AggregateRun, the results are in the same order as the aggregators
+func (m *MultiAggregator) AggregateRun(runDate time.Time) ([][]AggregationResult, error) {
+	log.Printf("starting aggregation of run %s", runDate)
+	rowsCh, errCh, err := m.duReader.ReadRun(runDate)
+	if err != nil {
+		return nil, err
+	}
+	return m.aggregate(rowsCh, errCh)
+}
+
+// ListRuns lists the runs available to aggregate, newest first
+func (m *MultiAggregator) ListRuns() ([]du.Run, error) {
+	return m.duReader.ListRuns()
+}
+
+func (m *MultiAggregator) aggregate(rowsCh <-chan du.Row, errCh <-chan error) ([][]AggregationResult, error) {
+	accumulations := make([]*accumulation, len(m.aggregators))
+	for i, aggregator := range m.aggregators {
+		accumulations[i] = aggregator.newAccumulation()
+	}
+	var topDirs *topDirsTracker
+	if m.topDirsCount > 0 {
+		topDirs = newTopDirsTracker(m.topDirsCount, m.topDirsDepth)
+	}
+	i := 0
+	for rowsCh != nil && errCh != nil {
+		select {
+		case err, ok := <-errCh:
+			if !ok {
+				errCh = nil
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+		case row, ok := <-rowsCh:
+			if !ok {
+				rowsCh = nil
+				continue
+			}
+			for _, acc := range accumulations {
+				acc.add(row)
+			}
+			if topDirs != nil {
+				topDirs.add(row)
+			}
+			if i%10000 == 0 {
+				log.Printf("%d disk usage rows processed so far", i)
+			}
+			i++
+		}
+	}
+	log.Printf("done aggregating blob inventory, %d du rows processed", i)
+
+	aggregationResultsPerAggregator := make([][]AggregationResult, len(accumulations))
+	for i, acc := range accumulations {
+		aggregationResultsPerAggregator[i] = acc.finish()
+	}
+	if topDirs != nil {
+		m.mu.Lock()
+		m.lastTopDirs = topDirs.top()
+		m.mu.Unlock()
+	}
+	return aggregationResultsPerAggregator, nil
+}
@@ -0,0 +1,26 @@
+package agg
+
+import (
+	"fmt"
+
+	"github.com/oriser/regroup"
+)
+
+// PathLabelsRule returns a rule that assigns the dir names to the labels by position,
+// e.g. [container, project, dataset] gives container/project/dataset/more the labels of the first three dir names.
+// Labels without a dir name (for less deep dirs) get their default. It's meant as the last rule, after the overrides.
+func PathLabelsRule(labelNames []string, labelsWithDefaults Labels) (AggregationRule, error) {
+	segments := make([]string, len(labelNames))
+	for i, labelName := range labelNames {
+		if _, declared := labelsWithDefaults[labelName]; !declared {
+			return AggregationRule{}, fmt.Errorf("path label %q is not declared in labels", labelName)
+		}
+		segments[i] = "(?P<" + labelName + ">[^/]+)"
+	}
+	pattern := nestedOptionalPattern(segments)
+	reGroup, err := regroup.Compile(pattern)
+	if err != nil {
+		return AggregationRule{}, fmt.Errorf("invalid path labels %v: %w", labelNames, err)
+	}
+	return AggregationRule{Pattern: ReGroup{ReGroup: reGroup, original: pattern}}, nil
+}
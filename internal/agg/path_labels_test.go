@@ -0,0 +1,30 @@
+package agg
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPathLabelsRule(t *testing.T) {
+	labelsWithDefaults := Labels{"container": "", "project": "none", "dataset": "none"}
+	pathLabelsRule, err := PathLabelsRule([]string{"container", "project", "dataset"}, labelsWithDefaults)
+	require.Nil(t, err)
+	assert.Equal(t, `^(?P<container>[^/]+)(?:/(?P<project>[^/]+)(?:/(?P<dataset>[^/]+))?)?`, pathLabelsRule.String())
+
+	rules := []AggregationRule{
+		{Pattern: NewReGroup(`^(?P<container>special)/(?P<dataset>[^/]+)`), StaticLabels: Labels{"project": "special"}},
+		pathLabelsRule,
+	}
+	tests := []RuleTest{
+		{Dir: "c/p/d/more", Labels: Labels{"container": "c", "project": "p", "dataset": "d"}},
+		{Dir: "c/p", Labels: Labels{"container": "c", "project": "p", "dataset": "none"}},
+		{Dir: "c", Labels: Labels{"container": "c", "project": "none", "dataset": "none"}},
+		{Dir: "special/d", Labels: Labels{"container": "special", "project": "special", "dataset": "d"}},
+	}
+	assert.Nil(t, Validate(labelsWithDefaults, rules, tests))
+
+	_, err = PathLabelsRule([]string{"container", "level1"}, labelsWithDefaults)
+	assert.EqualError(t, err, `path label "level1" is not declared in labels`)
+}
@@ -0,0 +1,161 @@
+package agg
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"golang.org/x/exp/maps"
+	"gopkg.in/yaml.v2"
+)
+
+// PrefixMap maps dir prefixes (e.g. tenants/acme) to labels. A dir gets the labels of the longest prefix it starts with.
+// Prefixes match whole dir names, so tenants/acme matches tenants/acme/data but not tenants/acme2.
+// It's looked up in a trie of dir names, so it stays fast with thousands of prefixes.
+type PrefixMap struct {
+	labelsByPrefix map[string]Labels
+	root           *prefixNode
+	// duplicates are prefixes that are the same after normalizing (e.g. a/ and a)
+	duplicates []string
+}
+
+type prefixNode struct {
+	children map[string]*prefixNode
+	prefix   string
+	labels   Labels
+}
+
+func NewPrefixMap(labelsByPrefix map[string]Labels) *PrefixMap {
+	m := &PrefixMap{labelsByPrefix: labelsByPrefix, root: &prefixNode{}}
+	prefixes := maps.Keys(labelsByPrefix)
+	slices.Sort(prefixes)
+	for _, prefix := range prefixes {
+		node := m.root
+		for _, name := range splitDir(prefix) {
+			child, exists := node.children[name]
+			if !exists {
+				if node.children == nil {
+					node.children = make(map[string]*prefixNode)
+				}
+				child = &prefixNode{}
+				node.children[name] = child
+			}
+			node = child
+		}
+		if node.labels != nil {
+			m.duplicates = append(m.duplicates, prefix)
+		}
+		node.prefix = prefix
+		node.labels = labelsByPrefix[prefix]
+		if node.labels == nil {
+			node.labels = Labels{}
+		}
+	}
+	return m
+}
+
+// LoadPrefixMap reads a PrefixMap from a YAML file (prefix: labels)
+func LoadPrefixMap(file string) (*PrefixMap, error) {
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	m := new(PrefixMap)
+	if err := yaml.UnmarshalStrict(content, m); err != nil {
+		return nil, fmt.Errorf("invalid prefixes file %s: %w", file, err)
+	}
+	return m, nil
+}
+
+// LoadPrefixesFiles loads the PrefixesFile of the rules (relative to baseDir) into their Prefixes
+func LoadPrefixesFiles(rules []AggregationRule, baseDir string) error {
+	for i := range rules {
+		file := rules[i].PrefixesFile
+		if file == "" {
+			continue
+		}
+		if rules[i].Prefixes != nil {
+			return fmt.Errorf("rule %d has both prefixes and prefixesFile", i)
+		}
+		if !filepath.IsAbs(file) {
+			file = filepath.Join(baseDir, file)
+		}
+		prefixMap, err := LoadPrefixMap(file)
+		if err != nil {
+			return err
+		}
+		rules[i].Prefixes = prefixMap
+	}
+	return nil
+}
+
+// lookup returns the longest prefix of the dir with its labels
+func (m *PrefixMap) lookup(dir string) (string, Labels, bool) {
+	node := m.root
+	found := node.labels != nil
+	prefix, labels := node.prefix, node.labels
+	for _, name := range splitDir(dir) {
+		node = node.children[name]
+		if node == nil {
+			break
+		}
+		if node.labels != nil {
+			found = true
+			prefix, labels = node.prefix, node.labels
+		}
+	}
+	return prefix, maps.Clone(labels), found
+}
+
+func (m *PrefixMap) Len() int {
+	return len(m.labelsByPrefix)
+}
+
+func (m *PrefixMap) UnmarshalYAML(unmarshal func(any) error) error {
+	var labelsByPrefix map[string]Labels
+	if err := unmarshal(&labelsByPrefix); err != nil {
+		return err
+	}
+	*m = *NewPrefixMap(labelsByPrefix)
+	return nil
+}
+
+func (m *PrefixMap) MarshalYAML() (interface{}, error) {
+	return m.labelsByPrefix, nil
+}
+
+func (m *PrefixMap) validate(i int, labelsWithDefaults Labels) []Issue {
+	if m == nil {
+		return nil
+	}
+	var issues []Issue
+	if m.Len() == 0 {
+		issues = append(issues, Issue{SeverityWarning, fmt.Sprintf("rule %d has no prefixes, so it never matches", i)})
+	}
+	for _, prefix := range m.duplicates {
+		issues = append(issues, Issue{SeverityError, fmt.Sprintf("rule %d has prefix %q more than once (ignoring leading and trailing slashes)", i, prefix)})
+	}
+	var undeclared []string
+	for _, labels := range m.labelsByPrefix {
+		for labelName := range labels {
+			if _, declared := labelsWithDefaults[labelName]; !declared && !slices.Contains(undeclared, labelName) {
+				undeclared = append(undeclared, labelName)
+			}
+		}
+	}
+	slices.Sort(undeclared)
+	for _, labelName := range undeclared {
+		issues = append(issues, Issue{SeverityWarning, fmt.Sprintf("rule %d has prefix label %q which is not declared in labels, so it is ignored", i, labelName)})
+	}
+	return issues
+}
+
+func splitDir(dir string) []string {
+	dir = strings.Trim(dir, "/")
+	if dir == "" {
+		return nil
+	}
+	return strings.Split(dir, "/")
+}
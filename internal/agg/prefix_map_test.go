@@ -0,0 +1,54 @@
+package agg
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v2"
+)
+
+func TestPrefixMap_lookup(t *testing.T) {
+	var prefixMap PrefixMap
+	require.Nil(t, yaml.Unmarshal([]byte(`
+tenants/acme: {tenant: acme}
+tenants/acme/archive/: {tenant: acme, type: archive}
+/tenants/globex: {tenant: globex}
+shared: {}
+`), &prefixMap))
+	assert.Equal(t, 4, prefixMap.Len())
+
+	tests := []struct {
+		dir        string
+		wantPrefix string
+		wantLabels Labels
+		wantFound  bool
+	}{
+		{dir: "tenants/acme", wantPrefix: "tenants/acme", wantLabels: Labels{"tenant": "acme"}, wantFound: true},
+		{dir: "tenants/acme/data/2024", wantPrefix: "tenants/acme", wantLabels: Labels{"tenant": "acme"}, wantFound: true},
+		{dir: "tenants/acme/archive/2020", wantPrefix: "tenants/acme/archive/", wantLabels: Labels{"tenant": "acme", "type": "archive"}, wantFound: true},
+		{dir: "tenants/globex/x", wantPrefix: "/tenants/globex", wantLabels: Labels{"tenant": "globex"}, wantFound: true},
+		{dir: "shared/x", wantPrefix: "shared", wantLabels: Labels{}, wantFound: true},
+		{dir: "tenants/acme2/x", wantFound: false},
+		{dir: "tenants", wantFound: false},
+	}
+	for _, tt := range tests {
+		prefix, labels, found := prefixMap.lookup(tt.dir)
+		assert.Equal(t, tt.wantFound, found, tt.dir)
+		if tt.wantFound {
+			assert.Equal(t, tt.wantPrefix, prefix, tt.dir)
+			assert.Equal(t, tt.wantLabels, labels, tt.dir)
+		}
+	}
+}
+
+func TestPrefixMap_validate(t *testing.T) {
+	prefixMap := NewPrefixMap(map[string]Labels{
+		"a":  {"tenant": "a"},
+		"a/": {"tenant": "b", "team": "x"},
+	})
+	assert.Equal(t, []Issue{
+		{SeverityError, `rule 0 has prefix "a/" more than once (ignoring leading and trailing slashes)`},
+		{SeverityWarning, `rule 0 has prefix label "team" which is not declared in labels, so it is ignored`},
+	}, prefixMap.validate(0, Labels{"tenant": "other"}))
+}
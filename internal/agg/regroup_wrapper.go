@@ -35,3 +35,7 @@ func (r ReGroup) MarshalYAML() (interface{}, error) {
 	}
 	return r.original, nil
 }
+
+func (r ReGroup) String() string {
+	return r.original
+}
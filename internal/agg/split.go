@@ -0,0 +1,162 @@
+package agg
+
+import (
+	"cmp"
+	"fmt"
+	"math"
+	"slices"
+
+	"github.com/PDOK/azure-storage-usage-exporter/internal/du"
+	"github.com/creasty/defaults"
+	"golang.org/x/exp/maps"
+)
+
+// Share is a part of the data matched by a rule, see AggregationRule.Split
+type Share struct {
+	// Labels override the labels that the rule results in, e.g. tenant: foo
+	Labels Labels `yaml:"labels"`
+	// Weight is relative to the weights of the other shares
+	Weight float64 `yaml:"weight" default:"1"`
+}
+
+type unmarshalledShare Share
+
+func (s *Share) UnmarshalYAML(unmarshal func(any) error) error {
+	tmp := new(unmarshalledShare)
+	if err := defaults.Set(tmp); err != nil {
+		return err
+	}
+	if err := unmarshal(tmp); err != nil {
+		return err
+	}
+	// not only in validateSplit, because commands aggregate without validating and splitInt needs positive weights
+	if !validWeight(tmp.Weight) {
+		return fmt.Errorf("share has an invalid weight %v, it must be positive", tmp.Weight)
+	}
+	*s = Share(*tmp)
+	return nil
+}
+
+// SplitShare is the aggregation group and fraction of a single Share, see Explanation
+type SplitShare struct {
+	AggregationGroup AggregationGroup `json:"aggregationGroup"`
+	Fraction         float64          `json:"fraction"`
+}
+
+// splitGroups returns the aggregation group of each share
+func (a *Aggregator) splitGroups(aggregationGroup AggregationGroup, shares []Share) []AggregationGroup {
+	groups := make([]AggregationGroup, len(shares))
+	for i, share := range shares {
+		labels := maps.Clone(aggregationGroup.Labels)
+		for label, value := range share.Labels {
+			if _, declared := a.labelsWithDefaults[label]; declared {
+				labels[label] = value
+			}
+		}
+		groups[i] = AggregationGroup{Labels: labels, Deleted: aggregationGroup.Deleted}
+	}
+	return groups
+}
+
+// explainSplit returns the share groups with their fraction, nil when there are no shares
+func (a *Aggregator) explainSplit(aggregationGroup AggregationGroup, shares []Share) []SplitShare {
+	if len(shares) == 0 {
+		return nil
+	}
+	var totalWeight float64
+	for _, share := range shares {
+		totalWeight += share.Weight
+	}
+	splitShares := make([]SplitShare, len(shares))
+	for i, group := range a.splitGroups(aggregationGroup, shares) {
+		splitShares[i] = SplitShare{AggregationGroup: group, Fraction: shares[i].Weight / totalWeight}
+	}
+	return splitShares
+}
+
+// splitRow divides the bytes and counts of the row over the shares, proportional to their weight.
+// The parts are whole numbers that add up to exactly the original.
+func splitRow(row du.Row, shares []Share) []du.Row {
+	weights := make([]float64, len(shares))
+	for i, share := range shares {
+		weights[i] = share.Weight
+	}
+	parts := make([]du.Row, len(shares))
+	for i := range parts {
+		parts[i] = row
+	}
+	for i, bytes := range splitInt(row.Bytes, weights) {
+		parts[i].Bytes = bytes
+	}
+	for i, count := range splitInt(row.Count, weights) {
+		parts[i].Count = count
+	}
+	if row.SizeBuckets != nil {
+		for i := range parts {
+			parts[i].SizeBuckets = make(du.SizeBuckets, len(row.SizeBuckets))
+		}
+		for bucket, count := range row.SizeBuckets {
+			for i, part := range splitInt(count, weights) {
+				parts[i].SizeBuckets[bucket] = part
+			}
+		}
+	}
+	return parts
+}
+
+// splitInt divides total proportional to the weights, using the largest remainder method
+func splitInt(total int64, weights []float64) []int64 {
+	var totalWeight float64
+	for _, weight := range weights {
+		totalWeight += weight
+	}
+	parts := make([]int64, len(weights))
+	remainders := make([]float64, len(weights))
+	rest := total
+	for i, weight := range weights {
+		exact := float64(total) * weight / totalWeight
+		parts[i] = int64(math.Floor(exact))
+		remainders[i] = exact - float64(parts[i])
+		rest -= parts[i]
+	}
+	order := make([]int, len(weights))
+	for i := range order {
+		order[i] = i
+	}
+	slices.SortStableFunc(order, func(a, b int) int {
+		return cmp.Compare(remainders[b], remainders[a])
+	})
+	for i := 0; rest > 0; i++ {
+		parts[order[i%len(order)]]++
+		rest--
+	}
+	for i := 0; rest < 0; i++ { // only due to floating point rounding
+		parts[order[len(order)-1-i%len(order)]]--
+		rest++
+	}
+	return parts
+}
+
+func validWeight(weight float64) bool {
+	return weight > 0 && !math.IsInf(weight, 0) && !math.IsNaN(weight)
+}
+
+func validateSplit(i int, shares []Share, labelsWithDefaults Labels) []Issue {
+	var issues []Issue
+	for j, share := range shares {
+		if !validWeight(share.Weight) {
+			issues = append(issues, Issue{SeverityError, fmt.Sprintf("rule %d share %d has an invalid weight %v, it must be positive", i, j, share.Weight)})
+		}
+		labelNames := maps.Keys(share.Labels)
+		slices.Sort(labelNames)
+		for _, labelName := range labelNames {
+			if _, declared := labelsWithDefaults[labelName]; !declared {
+				issues = append(issues, Issue{SeverityWarning, fmt.Sprintf("rule %d share %d has label %q which is not declared in labels, so it is ignored", i, j, labelName)})
+			}
+		}
+	}
+	if len(shares) == 1 {
+		issues = append(issues, Issue{SeverityWarning, fmt.Sprintf("rule %d splits into a single share, which is the same as static labels", i)})
+	}
+	return issues
+}
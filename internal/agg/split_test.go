@@ -0,0 +1,91 @@
+package agg
+
+import (
+	"testing"
+	"time"
+
+	"github.com/PDOK/azure-storage-usage-exporter/internal/du"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v2"
+)
+
+func TestShare_UnmarshalYAML(t *testing.T) {
+	tests := []struct {
+		yaml    string
+		want    Share
+		wantErr bool
+	}{
+		{yaml: `labels: {tenant: foo}`, want: Share{Labels: Labels{"tenant": "foo"}, Weight: 1}},
+		{yaml: `weight: 0.5`, want: Share{Weight: 0.5}},
+		{yaml: `weight: 0`, wantErr: true},
+		{yaml: `weight: -1`, wantErr: true},
+		{yaml: `weight: .nan`, wantErr: true},
+	}
+	for _, tt := range tests {
+		var got Share
+		err := yaml.UnmarshalStrict([]byte(tt.yaml), &got)
+		if tt.wantErr {
+			assert.Error(t, err, tt.yaml)
+			continue
+		}
+		require.NoError(t, err)
+		assert.Equal(t, tt.want, got)
+	}
+}
+
+func Test_splitInt(t *testing.T) {
+	tests := []struct {
+		total   int64
+		weights []float64
+		want    []int64
+	}{
+		{total: 100, weights: []float64{1, 1}, want: []int64{50, 50}},
+		{total: 101, weights: []float64{1, 1}, want: []int64{51, 50}},
+		{total: 100, weights: []float64{1, 1, 1}, want: []int64{34, 33, 33}},
+		{total: 10, weights: []float64{0.7, 0.2, 0.1}, want: []int64{7, 2, 1}},
+		{total: 2, weights: []float64{1, 1, 1}, want: []int64{1, 1, 0}},
+		{total: 0, weights: []float64{3, 1}, want: []int64{0, 0}},
+		{total: 9_007_199_254_740_993, weights: []float64{1, 2}, want: []int64{3_002_399_751_580_331, 6_004_799_503_160_662}},
+	}
+	for _, tt := range tests {
+		got := splitInt(tt.total, tt.weights)
+		assert.Equal(t, tt.want, got)
+		var sum int64
+		for _, part := range got {
+			sum += part
+		}
+		assert.Equal(t, tt.total, sum)
+	}
+}
+
+func TestAggregator_Aggregate_split(t *testing.T) {
+	someFixedTime, _ := time.Parse(time.DateOnly, "2024-04-20")
+	a, err := NewAggregator(&fakeDuReader{
+		runDate: someFixedTime,
+		rows: []du.Row{
+			{Dir: "shared/dataset", Deleted: boolPtr(false), AccessTier: "Hot", Bytes: 1001, Count: 3},
+			{Dir: "foo/own", Deleted: boolPtr(false), AccessTier: "Hot", Bytes: 10, Count: 1},
+		},
+	}, Labels{"tenant": "other", "type": "other", StorageAccount: ""}, []AggregationRule{
+		{Pattern: NewReGroup(`^shared/(?P<type>[^/]+)`), Split: []Share{
+			{Labels: Labels{"tenant": "foo"}, Weight: 3},
+			{Labels: Labels{"tenant": "bar"}, Weight: 1},
+		}},
+		{Pattern: NewReGroup(`^(?P<tenant>[^/]+)/(?P<type>[^/]+)`)},
+	})
+	require.Nil(t, err)
+	aggregationResults, _, err := a.Aggregate(time.Time{})
+	require.Nil(t, err)
+	assert.Equal(t, []AggregationResult{
+		{AggregationGroup: AggregationGroup{Labels: Labels{"tenant": "foo", "type": "dataset"}}, StorageUsage: 751, Count: 2, UsageByAccessTier: map[string]TierUsage{"Hot": {751, 2}}},
+		{AggregationGroup: AggregationGroup{Labels: Labels{"tenant": "bar", "type": "dataset"}}, StorageUsage: 250, Count: 1, UsageByAccessTier: map[string]TierUsage{"Hot": {250, 1}}},
+		{AggregationGroup: AggregationGroup{Labels: Labels{"tenant": "foo", "type": "own"}}, StorageUsage: 10, Count: 1, UsageByAccessTier: map[string]TierUsage{"Hot": {10, 1}}},
+	}, aggregationResults)
+
+	explanation := a.Explain(ExampleBlob{Dir: "shared/dataset"})
+	assert.Equal(t, []SplitShare{
+		{AggregationGroup: AggregationGroup{Labels: Labels{"tenant": "foo", "type": "dataset"}}, Fraction: 0.75},
+		{AggregationGroup: AggregationGroup{Labels: Labels{"tenant": "bar", "type": "dataset"}}, Fraction: 0.25},
+	}, explanation.Split)
+}
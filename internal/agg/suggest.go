@@ -0,0 +1,90 @@
+package agg
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/PDOK/azure-storage-usage-exporter/internal/du"
+)
+
+const suggestedLabelDefault = "other"
+
+// RuleSuggestion is a starting point for the labels and rules of a storage account, based on its dir structure
+type RuleSuggestion struct {
+	Total  du.StorageUsage
+	Depths []DepthSuggestion
+	Labels Labels
+	Rules  []AggregationRule
+}
+
+// DepthSuggestion tells how the dir names at a single depth are used in a RuleSuggestion
+type DepthSuggestion struct {
+	du.DepthProfile
+	// Label is the suggested label for the names at this depth, empty if none
+	Label string
+	// Literal is the name at this depth, when all dirs have the same name (so it's in the pattern instead of a label)
+	Literal string
+	// Reason tells why there is no label (or literal)
+	Reason string
+}
+
+// SuggestRules suggests a label per depth (container, level1, level2, ...), from the top down,
+// until there would be more than maxCardinality label combinations,
+// or less than minShare of the bytes is in dirs that deep.
+func SuggestRules(profiles []du.DepthProfile, total du.StorageUsage, maxCardinality int64, minShare float64) RuleSuggestion {
+	suggestion := RuleSuggestion{Total: total, Labels: Labels{}}
+	var reason string
+	var segments []string
+	lastLabel := 0
+	for _, profile := range profiles {
+		depth := DepthSuggestion{DepthProfile: profile}
+		share := float64(profile.Bytes) / float64(max(total, 1))
+		switch {
+		case reason != "":
+			depth.Reason = "below a depth without label"
+		case profile.DistinctPrefixes > maxCardinality:
+			reason = fmt.Sprintf("%d dirs is more than the max cardinality %d", profile.DistinctPrefixes, maxCardinality)
+			depth.Reason = reason
+		case share < minShare:
+			reason = fmt.Sprintf("only %.1f%% of the bytes is this deep", 100*share)
+			depth.Reason = reason
+		case profile.Distinct == 1 && len(profile.Largest) == 1 && profile.Depth > 1:
+			depth.Literal = profile.Largest[0].Name
+			segments = append(segments, regexp.QuoteMeta(depth.Literal))
+		default:
+			depth.Label = suggestedLabelName(profile.Depth)
+			suggestion.Labels[depth.Label] = suggestedLabelDefault
+			segments = append(segments, "(?P<"+depth.Label+">[^/]+)")
+			lastLabel = len(segments)
+		}
+		suggestion.Depths = append(suggestion.Depths, depth)
+	}
+	if lastLabel > 0 {
+		suggestion.Rules = []AggregationRule{{Pattern: NewReGroup(nestedOptionalPattern(segments[:lastLabel]))}}
+	}
+	return suggestion
+}
+
+func suggestedLabelName(depth int) string {
+	if depth == 1 {
+		return "container"
+	}
+	return fmt.Sprintf("level%d", depth-1)
+}
+
+// nestedOptionalPattern returns a pattern that matches the first segment, optionally followed by the next, etc.
+func nestedOptionalPattern(segments []string) string {
+	var sb strings.Builder
+	sb.WriteString("^")
+	for i, segment := range segments {
+		if i > 0 {
+			sb.WriteString("(?:/")
+		}
+		sb.WriteString(segment)
+	}
+	if len(segments) > 1 {
+		sb.WriteString(strings.Repeat(")?", len(segments)-1))
+	}
+	return sb.String()
+}
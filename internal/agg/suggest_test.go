@@ -0,0 +1,43 @@
+package agg
+
+import (
+	"testing"
+
+	"github.com/PDOK/azure-storage-usage-exporter/internal/du"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSuggestRules(t *testing.T) {
+	profiles := []du.DepthProfile{
+		{Depth: 1, Distinct: 3, DistinctPrefixes: 3, Bytes: 1000, Largest: []du.NameUsage{{Name: "c1", Bytes: 800}, {Name: "c2", Bytes: 150}, {Name: "c3", Bytes: 50}}},
+		{Depth: 2, Distinct: 1, DistinctPrefixes: 1, Bytes: 900, Largest: []du.NameUsage{{Name: "data", Bytes: 900}}},
+		{Depth: 3, Distinct: 40, DistinctPrefixes: 60, Bytes: 850, Largest: []du.NameUsage{{Name: "t1", Bytes: 500}}},
+		{Depth: 4, Distinct: 5000, DistinctPrefixes: 20000, Bytes: 800, Largest: []du.NameUsage{{Name: "2024", Bytes: 300}}},
+		{Depth: 5, Distinct: 10, DistinctPrefixes: 30000, Bytes: 10, Largest: []du.NameUsage{{Name: "x", Bytes: 10}}},
+	}
+	suggestion := SuggestRules(profiles, 1000, 1000, 0.1)
+	assert.Equal(t, Labels{"container": "other", "level2": "other"}, suggestion.Labels)
+	assert.Len(t, suggestion.Rules, 1)
+	assert.Equal(t, `^(?P<container>[^/]+)(?:/data(?:/(?P<level2>[^/]+))?)?`, suggestion.Rules[0].String())
+	var outcomes []string
+	for _, depth := range suggestion.Depths {
+		outcomes = append(outcomes, depth.Label+depth.Literal+depth.Reason)
+	}
+	assert.Equal(t, []string{
+		"container",
+		"data",
+		"level2",
+		"20000 dirs is more than the max cardinality 1000",
+		"below a depth without label",
+	}, outcomes)
+
+	// the suggested rule works
+	assert.Nil(t, Validate(suggestion.Labels, suggestion.Rules, []RuleTest{
+		{Dir: "c1/data/t1/2024", Labels: Labels{"container": "c1", "level2": "t1"}},
+		{Dir: "c2/other/t1", Labels: Labels{"container": "c2", "level2": "other"}},
+	}))
+
+	suggestion = SuggestRules(profiles, 1000, 1000, 0.95)
+	assert.Equal(t, Labels{"container": "other"}, suggestion.Labels)
+	assert.Equal(t, "only 90.0% of the bytes is this deep", suggestion.Depths[1].Reason)
+}
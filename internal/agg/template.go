@@ -0,0 +1,77 @@
+package agg
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"text/template"
+
+	"golang.org/x/exp/maps"
+)
+
+// labelTemplates caches parsed label value templates by their text
+var labelTemplates sync.Map
+
+var labelTemplateFuncs = template.FuncMap{
+	"lower":      strings.ToLower,
+	"upper":      strings.ToUpper,
+	"trimPrefix": strings.TrimPrefix,
+	"trimSuffix": strings.TrimSuffix,
+	"replace":    strings.ReplaceAll,
+}
+
+// isTemplate tells whether a static label value or label default is a template, e.g. {{.container}}-{{.dataset}}
+func isTemplate(value string) bool {
+	return strings.Contains(value, "{{")
+}
+
+func parseLabelTemplate(value string) (*template.Template, error) {
+	if cached, ok := labelTemplates.Load(value); ok {
+		return cached.(*template.Template), nil
+	}
+	parsed, err := template.New("label").Funcs(labelTemplateFuncs).Option("missingkey=zero").Parse(value)
+	if err != nil {
+		return nil, err
+	}
+	labelTemplates.Store(value, parsed)
+	return parsed, nil
+}
+
+// renderLabel executes the value as template against the data (named groups and labels),
+// a value that isn't a template is returned as is. It's empty when the template is invalid.
+func renderLabel(value string, data map[string]string) string {
+	if !isTemplate(value) {
+		return value
+	}
+	parsed, err := parseLabelTemplate(value)
+	if err != nil {
+		return ""
+	}
+	var sb strings.Builder
+	if err := parsed.Execute(&sb, data); err != nil {
+		return ""
+	}
+	return sb.String()
+}
+
+// usedInTemplates tells whether a named group is (probably) used in the templates of the rule or the label defaults
+func usedInTemplates(groupName string, rule AggregationRule, labelsWithDefaults Labels) bool {
+	reference := regexp.MustCompile(`\.` + regexp.QuoteMeta(groupName) + `\b`)
+	for _, value := range append(maps.Values(rule.StaticLabels), maps.Values(labelsWithDefaults)...) {
+		if isTemplate(value) && reference.MatchString(value) {
+			return true
+		}
+	}
+	return false
+}
+
+func validateLabelTemplate(value string, what string) []Issue {
+	if !isTemplate(value) {
+		return nil
+	}
+	if _, err := parseLabelTemplate(value); err != nil {
+		return []Issue{{SeverityError, fmt.Sprintf("%s has an invalid template: %s", what, err)}}
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+package agg
+
+import (
+	"cmp"
+	"slices"
+	"strings"
+
+	"github.com/PDOK/azure-storage-usage-exporter/internal/du"
+)
+
+// TopDir is the usage of a single dir (truncated to a depth), independent of labels and rules.
+// Bytes and Count include (soft) deleted blobs.
+type TopDir struct {
+	Dir   string
+	Bytes du.StorageUsage
+	Count int64
+}
+
+type topDirsTracker struct {
+	n     int
+	depth int
+	dirs  map[string]*TopDir
+}
+
+func newTopDirsTracker(n, depth int) *topDirsTracker {
+	return &topDirsTracker{n: n, depth: depth, dirs: make(map[string]*TopDir)}
+}
+
+func (t *topDirsTracker) add(row du.Row) {
+	dir := truncateDir(row.Dir, t.depth)
+	topDir, exists := t.dirs[dir]
+	if !exists {
+		topDir = &TopDir{Dir: dir}
+		t.dirs[dir] = topDir
+	}
+	topDir.Bytes += row.Bytes
+	topDir.Count += row.Count
+}
+
+func (t *topDirsTracker) top() []TopDir {
+	topDirs := make([]TopDir, 0, len(t.dirs))
+	for _, topDir := range t.dirs {
+		topDirs = append(topDirs, *topDir)
+	}
+	slices.SortFunc(topDirs, func(a, b TopDir) int {
+		return cmp.Or(cmp.Compare(b.Bytes, a.Bytes), cmp.Compare(a.Dir, b.Dir))
+	})
+	if len(topDirs) > t.n {
+		return topDirs[:t.n]
+	}
+	return topDirs
+}
+
+// truncateDir keeps the first depth segments of dir, depth 0 keeps the dir as is
+func truncateDir(dir string, depth int) string {
+	if depth <= 0 {
+		return dir
+	}
+	segments := strings.SplitN(dir, "/", depth+1)
+	if len(segments) > depth {
+		segments = segments[:depth]
+	}
+	return strings.Join(segments, "/")
+}
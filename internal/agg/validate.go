@@ -0,0 +1,352 @@
+package agg
+
+import (
+	"fmt"
+	"regexp/syntax"
+	"slices"
+	"strings"
+	"unicode"
+
+	"github.com/PDOK/azure-storage-usage-exporter/internal/du"
+	"github.com/prometheus/common/model"
+	"golang.org/x/exp/maps"
+)
+
+const (
+	maxSamplesPerRule = 32
+	maxRepeatInSample = 2
+)
+
+type Severity string
+
+const (
+	SeverityError   Severity = "error"
+	SeverityWarning Severity = "warning"
+)
+
+// Issue is a (possible) mistake in the labels, rules or rule tests
+type Issue struct {
+	Severity Severity
+	Message  string
+}
+
+func (i Issue) String() string {
+	return string(i.Severity) + ": " + i.Message
+}
+
+// RuleTest is an example dir with the labels it is expected to get
+type RuleTest struct {
+	Dir     string `yaml:"dir"`
+	Deleted bool   `yaml:"deleted,omitempty"`
+	// Tags and Metadata are the (optional) blob index tags and metadata of the example blobs
+	Tags     du.Attributes `yaml:"tags,omitempty"`
+	Metadata du.Attributes `yaml:"metadata,omitempty"`
+	// AccessTier, BlobType, ContentType, Size and AgeDays are the (optional) attributes of the example blobs
+	AccessTier  string       `yaml:"accessTier,omitempty"`
+	BlobType    string       `yaml:"blobType,omitempty"`
+	ContentType string       `yaml:"contentType,omitempty"`
+	Size        *du.ByteSize `yaml:"size,omitempty"`
+	AgeDays     *int64       `yaml:"ageDays,omitempty"`
+	// Excluded tells whether the dir is expected to be dropped by a rule, the labels aren't checked then
+	Excluded bool `yaml:"excluded,omitempty"`
+	// Labels are the expected labels, labels that are not given are not checked
+	Labels Labels `yaml:"labels"`
+}
+
+func (t RuleTest) row() du.Row {
+	row := ExampleBlob{
+		Dir:         t.Dir,
+		Deleted:     t.Deleted,
+		AccessTier:  t.AccessTier,
+		BlobType:    t.BlobType,
+		ContentType: t.ContentType,
+		Size:        t.Size,
+		AgeDays:     t.AgeDays,
+	}.row()
+	row.Tags, row.Metadata = t.Tags, t.Metadata
+	return row
+}
+
+// Validate checks labels and rules for mistakes that would otherwise silently result in wrong metrics,
+// and checks whether the rule tests pass
+func Validate(labelsWithDefaults Labels, rules []AggregationRule, tests []RuleTest) []Issue {
+	var issues []Issue
+	issues = append(issues, validateLabelNames(labelsWithDefaults)...)
+	for i, rule := range rules {
+		issues = append(issues, validateRule(i, rule, labelsWithDefaults)...)
+	}
+	issues = append(issues, findShadowedRules(rules)...)
+	issues = append(issues, runRuleTests(labelsWithDefaults, rules, tests)...)
+	return issues
+}
+
+// HasErrors reports whether any of the issues is an error (instead of a warning)
+func HasErrors(issues []Issue) bool {
+	return slices.ContainsFunc(issues, func(issue Issue) bool {
+		return issue.Severity == SeverityError
+	})
+}
+
+func validateLabelNames(labelsWithDefaults Labels) []Issue {
+	var issues []Issue
+	labelNames := maps.Keys(labelsWithDefaults)
+	slices.Sort(labelNames)
+	for _, labelName := range labelNames {
+		if !model.LabelName(labelName).IsValid() || strings.HasPrefix(labelName, "__") {
+			issues = append(issues, Issue{SeverityError, fmt.Sprintf("label %q is not a valid Prometheus label name", labelName)})
+		}
+		if labelName == Deleted {
+			issues = append(issues, Issue{SeverityError, "cannot use custom label: " + Deleted})
+		}
+		issues = append(issues, validateLabelTemplate(labelsWithDefaults[labelName], fmt.Sprintf("the default of label %q", labelName))...)
+	}
+	return issues
+}
+
+func validateRule(i int, rule AggregationRule, labelsWithDefaults Labels) []Issue {
+	var issues []Issue
+	switch kinds := countTrue(rule.Pattern.ReGroup != nil, rule.Glob.ReGroup.ReGroup != nil, rule.Prefixes != nil); {
+	case kinds == 0:
+		return []Issue{{SeverityError, fmt.Sprintf("rule %d has no pattern, glob or prefixes", i)}}
+	case kinds > 1:
+		return []Issue{{SeverityError, fmt.Sprintf("rule %d has more than one of pattern, glob and prefixes", i)}}
+	}
+	if pattern := rule.pattern(); pattern.ReGroup != nil {
+		parsed, err := syntax.Parse(pattern.String(), syntax.Perl)
+		if err != nil { // unexpected, it compiled before
+			return []Issue{{SeverityError, fmt.Sprintf("rule %d has an invalid pattern: %s", i, err)}}
+		}
+		for _, groupName := range parsed.CapNames() {
+			if _, declared := labelsWithDefaults[groupName]; groupName != "" && !declared && !usedInTemplates(groupName, rule, labelsWithDefaults) {
+				issues = append(issues, Issue{SeverityWarning, fmt.Sprintf("rule %d has named group %q which is not declared in labels, so it is ignored", i, groupName)})
+			}
+		}
+	}
+	issues = append(issues, rule.Prefixes.validate(i, labelsWithDefaults)...)
+	staticLabelNames := maps.Keys(rule.StaticLabels)
+	slices.Sort(staticLabelNames)
+	for _, labelName := range staticLabelNames {
+		if _, declared := labelsWithDefaults[labelName]; !declared {
+			issues = append(issues, Issue{SeverityWarning, fmt.Sprintf("rule %d has static label %q which is not declared in labels, so it is ignored", i, labelName)})
+		}
+		issues = append(issues, validateLabelTemplate(rule.StaticLabels[labelName], fmt.Sprintf("rule %d static label %q", i, labelName))...)
+	}
+	for _, attributeLabels := range []struct {
+		kind        string
+		keysByLabel map[string]string
+	}{{"tag", rule.TagLabels}, {"metadata", rule.MetadataLabels}} {
+		kind, keysByLabel := attributeLabels.kind, attributeLabels.keysByLabel
+		attributeLabelNames := maps.Keys(keysByLabel)
+		slices.Sort(attributeLabelNames)
+		for _, labelName := range attributeLabelNames {
+			if _, declared := labelsWithDefaults[labelName]; !declared {
+				issues = append(issues, Issue{SeverityWarning, fmt.Sprintf("rule %d has %s label %q which is not declared in labels, so it is ignored", i, kind, labelName)})
+			}
+			if keysByLabel[labelName] == "" {
+				issues = append(issues, Issue{SeverityError, fmt.Sprintf("rule %d has %s label %q without a key", i, kind, labelName)})
+			}
+		}
+	}
+	issues = append(issues, rule.Match.validate(i)...)
+	issues = append(issues, validateSplit(i, rule.Split, labelsWithDefaults)...)
+	if rule.Drop && (len(rule.StaticLabels) > 0 || len(rule.TagLabels) > 0 || len(rule.MetadataLabels) > 0) {
+		issues = append(issues, Issue{SeverityWarning, fmt.Sprintf("rule %d drops the data it matches, so its labels are ignored", i)})
+	}
+	return issues
+}
+
+// findShadowedRules finds rules that (probably) never match, because earlier rules match first.
+// This is a heuristic: sample dirs are generated from each pattern,
+// when all of them are matched by earlier rules, the rule is considered shadowed.
+// Earlier rules with conditions on blob attributes don't shadow, because they don't match all blobs.
+func findShadowedRules(rules []AggregationRule) []Issue {
+	var issues []Issue
+	for i, rule := range rules {
+		pattern := rule.pattern()
+		if pattern.ReGroup == nil || rule.Prefixes != nil {
+			continue
+		}
+		if j := slices.IndexFunc(rules[:i], func(earlierRule AggregationRule) bool {
+			earlierPattern := earlierRule.pattern()
+			return earlierPattern.ReGroup != nil && earlierRule.Prefixes == nil && earlierRule.Match == nil && earlierPattern.String() == pattern.String()
+		}); j >= 0 {
+			issues = append(issues, Issue{SeverityWarning, fmt.Sprintf("rule %d is unreachable, rule %d has the same pattern", i, j)})
+			continue
+		}
+		samples := generateSamples(pattern)
+		shadowedBy := -1
+		for _, sample := range samples {
+			matchedBy := firstMatchingRule(rules[:i], sample)
+			if matchedBy < 0 {
+				shadowedBy = -1
+				break
+			}
+			shadowedBy = max(shadowedBy, matchedBy)
+		}
+		if shadowedBy >= 0 {
+			issues = append(issues, Issue{SeverityWarning, fmt.Sprintf("rule %d is probably unreachable, all example dirs like %q are matched by earlier rules (e.g. rule %d)", i, samples[0], shadowedBy)})
+		}
+	}
+	return issues
+}
+
+func firstMatchingRule(rules []AggregationRule, dir string) int {
+	for i, rule := range rules {
+		if rule.Match != nil {
+			continue
+		}
+		if _, matched := rule.match(du.Row{Dir: dir}); matched {
+			return i
+		}
+	}
+	return -1
+}
+
+func countTrue(values ...bool) int {
+	var n int
+	for _, value := range values {
+		if value {
+			n++
+		}
+	}
+	return n
+}
+
+// generateSamples generates some strings that the pattern matches
+func generateSamples(pattern ReGroup) []string {
+	parsed, err := syntax.Parse(pattern.String(), syntax.Perl)
+	if err != nil {
+		return nil
+	}
+	var samples []string
+	for _, sample := range generate(parsed.Simplify()) {
+		if _, err := pattern.Groups(sample); err == nil && !slices.Contains(samples, sample) {
+			samples = append(samples, sample)
+		}
+	}
+	return samples
+}
+
+//nolint:cyclop // it's a big switch
+func generate(re *syntax.Regexp) []string {
+	switch re.Op {
+	case syntax.OpLiteral:
+		return []string{string(re.Rune)}
+	case syntax.OpCharClass:
+		return sampleRunes(re.Rune)
+	case syntax.OpAnyCharNotNL, syntax.OpAnyChar:
+		return []string{"a", "Z"}
+	case syntax.OpCapture:
+		return generate(re.Sub[0])
+	case syntax.OpStar:
+		return append([]string{""}, repeat(generate(re.Sub[0]), 1, maxRepeatInSample)...)
+	case syntax.OpPlus:
+		return repeat(generate(re.Sub[0]), 1, maxRepeatInSample)
+	case syntax.OpQuest:
+		return append([]string{""}, generate(re.Sub[0])...)
+	case syntax.OpRepeat:
+		maxRepeat := re.Max
+		if maxRepeat < 0 || maxRepeat > re.Min+maxRepeatInSample {
+			maxRepeat = re.Min + maxRepeatInSample
+		}
+		return repeat(generate(re.Sub[0]), re.Min, maxRepeat)
+	case syntax.OpConcat:
+		samples := []string{""}
+		for _, sub := range re.Sub {
+			samples = product(samples, generate(sub))
+		}
+		return samples
+	case syntax.OpAlternate:
+		var samples []string
+		for _, sub := range re.Sub {
+			samples = append(samples, generate(sub)...)
+		}
+		return limit(samples)
+	default: // empty match, anchors, word boundaries, no match
+		return []string{""}
+	}
+}
+
+func repeat(samples []string, minRepeat, maxRepeat int) []string {
+	var repeated []string
+	for n := minRepeat; n <= maxRepeat; n++ {
+		if n == 0 {
+			repeated = append(repeated, "")
+			continue
+		}
+		current := []string{""}
+		for range n {
+			current = product(current, samples)
+		}
+		repeated = append(repeated, current...)
+	}
+	return limit(repeated)
+}
+
+func product(prefixes, suffixes []string) []string {
+	var samples []string
+	for _, prefix := range prefixes {
+		for _, suffix := range suffixes {
+			samples = append(samples, prefix+suffix)
+		}
+	}
+	return limit(samples)
+}
+
+func limit(samples []string) []string {
+	if len(samples) > maxSamplesPerRule {
+		return samples[:maxSamplesPerRule]
+	}
+	return samples
+}
+
+// sampleRunes picks (preferably readable) runes from a char class (which is a list of rune ranges)
+func sampleRunes(ranges []rune) []string {
+	inClass := func(r rune) bool {
+		for i := 0; i+1 < len(ranges); i += 2 {
+			if ranges[i] <= r && r <= ranges[i+1] {
+				return true
+			}
+		}
+		return false
+	}
+	var samples []string
+	for _, candidate := range []rune{'a', 'z', 'A', '0', '9', '_', '-', '.'} {
+		if inClass(candidate) {
+			samples = append(samples, string(candidate))
+		}
+	}
+	if len(samples) == 0 && len(ranges) > 1 {
+		for r := ranges[0]; r <= ranges[1]; r++ {
+			if unicode.IsPrint(r) {
+				return []string{string(r)}
+			}
+		}
+		return []string{string(ranges[0])}
+	}
+	return samples
+}
+
+// runRuleTests checks whether the example dirs get the expected labels
+func runRuleTests(labelsWithDefaults Labels, rules []AggregationRule, tests []RuleTest) []Issue {
+	var issues []Issue
+	aggregator := &Aggregator{labelsWithDefaults: labelsWithDefaults, rules: rules}
+	for i, test := range tests {
+		aggregationGroup, ruleIndex := aggregator.applyRulesToAggregate(test.row())
+		if excluded := aggregator.isDropped(ruleIndex); excluded != test.Excluded {
+			issues = append(issues, Issue{SeverityError, fmt.Sprintf("test %d (%s): excluded is %t, expected %t", i, test.Dir, excluded, test.Excluded)})
+			continue
+		} else if excluded {
+			continue
+		}
+		labelNames := maps.Keys(test.Labels)
+		slices.Sort(labelNames)
+		for _, labelName := range labelNames {
+			want := test.Labels[labelName]
+			if got := aggregationGroup.Labels[labelName]; got != want {
+				issues = append(issues, Issue{SeverityError, fmt.Sprintf("test %d (%s): label %q is %q, expected %q", i, test.Dir, labelName, got, want)})
+			}
+		}
+	}
+	return issues
+}
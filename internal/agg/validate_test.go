@@ -0,0 +1,203 @@
+package agg
+
+import (
+	"testing"
+
+	"github.com/PDOK/azure-storage-usage-exporter/internal/du"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name               string
+		labelsWithDefaults Labels
+		rules              []AggregationRule
+		tests              []RuleTest
+		want               []Issue
+	}{{
+		name:               "valid",
+		labelsWithDefaults: Labels{"level1": "default1", "level2": "default2"},
+		rules: []AggregationRule{
+			{Pattern: NewReGroup(`^(?P<level1>special)(/|$)`), StaticLabels: Labels{"level2": "sauce"}},
+			{Pattern: NewReGroup(`^(?P<level1>[^/]+)/(?P<level2>[^/]+)`)},
+			{Pattern: NewReGroup(`^(?P<level1>[a-z]+)`)},
+		},
+		tests: []RuleTest{
+			{Dir: "special/dir", Labels: Labels{"level1": "special", "level2": "sauce"}},
+			{Dir: "dir1/dir2/dir3", Labels: Labels{"level1": "dir1", "level2": "dir2"}},
+			{Dir: "UPPER", Labels: Labels{"level1": "default1"}},
+		},
+		want: nil,
+	}, {
+		name:               "invalid label names",
+		labelsWithDefaults: Labels{"level-1": "", "__level2": "", Deleted: ""},
+		want: []Issue{
+			{SeverityError, `label "__level2" is not a valid Prometheus label name`},
+			{SeverityError, "cannot use custom label: deleted"},
+			{SeverityError, `label "level-1" is not a valid Prometheus label name`},
+		},
+	}, {
+		name:               "undeclared labels",
+		labelsWithDefaults: Labels{"level1": "default1"},
+		rules: []AggregationRule{
+			{Pattern: NewReGroup(`^(?P<level1>[^/]+)/(?P<level2>[^/]+)`), StaticLabels: Labels{"level3": "static"}},
+		},
+		want: []Issue{
+			{SeverityWarning, `rule 0 has named group "level2" which is not declared in labels, so it is ignored`},
+			{SeverityWarning, `rule 0 has static label "level3" which is not declared in labels, so it is ignored`},
+		},
+	}, {
+		name:               "shadowed rules",
+		labelsWithDefaults: Labels{"level1": "default1", "level2": "default2"},
+		rules: []AggregationRule{
+			{Pattern: NewReGroup(`^(?P<level1>[^/]+)/(?P<level2>[^/]+)`)},
+			{Pattern: NewReGroup(`^(?P<level1>special)/(?P<level2>[^/]+)`)},
+			{Pattern: NewReGroup(`^(?P<level1>[^/]+)/(?P<level2>[^/]+)`)},
+			{Pattern: NewReGroup(`^(?P<level1>[^/]+)$`)},
+		},
+		want: []Issue{
+			{SeverityWarning, `rule 1 is probably unreachable, all example dirs like "special/a" are matched by earlier rules (e.g. rule 0)`},
+			{SeverityWarning, "rule 2 is unreachable, rule 0 has the same pattern"},
+		},
+	}, {
+		name:               "tag and metadata labels",
+		labelsWithDefaults: Labels{"container": "", "owner": "unknown", "project": "unknown"},
+		rules: []AggregationRule{
+			{
+				Pattern:        NewReGroup(`^(?P<container>[^/]+)/(?P<project>[^/]+)`),
+				TagLabels:      Labels{"owner": "owner", "project": "project", "other": "x"},
+				MetadataLabels: Labels{"owner": ""},
+			},
+		},
+		tests: []RuleTest{
+			{Dir: "c/p", Tags: du.Attributes{"owner": "team-a", "project": "tagged"}, Labels: Labels{"container": "c", "owner": "team-a", "project": "tagged"}},
+			{Dir: "c/p", Tags: du.Attributes{"other": "x"}, Labels: Labels{"container": "c", "owner": "unknown", "project": "p"}},
+		},
+		want: []Issue{
+			{SeverityWarning, `rule 0 has tag label "other" which is not declared in labels, so it is ignored`},
+			{SeverityError, `rule 0 has metadata label "owner" without a key`},
+		},
+	}, {
+		name:               "rule conditions",
+		labelsWithDefaults: Labels{"container": "", "policy_violation": "false"},
+		rules: []AggregationRule{
+			{
+				Pattern:      NewReGroup(`^(?P<container>archive)(/|$)`),
+				StaticLabels: Labels{"policy_violation": "true"},
+				Match:        &RuleConditions{AccessTiers: []string{"hot"}, MinAgeDays: int64Ptr(30), Deleted: boolPtr(false)},
+			},
+			{Pattern: NewReGroup(`^(?P<container>archive)(/|$)`)},
+			{
+				Pattern: NewReGroup(`^(?P<container>[^/]+)`),
+				Match:   &RuleConditions{ContentTypes: []string{"image/["}, MinSize: byteSizePtr(10), MaxSize: byteSizePtr(10)},
+			},
+		},
+		tests: []RuleTest{
+			{Dir: "archive/x", AccessTier: "Hot", AgeDays: int64Ptr(31), Labels: Labels{"container": "archive", "policy_violation": "true"}},
+			{Dir: "archive/x", AccessTier: "Hot", AgeDays: int64Ptr(29), Labels: Labels{"policy_violation": "false"}},
+			{Dir: "archive/x", AccessTier: "Archive", AgeDays: int64Ptr(31), Labels: Labels{"policy_violation": "false"}},
+			{Dir: "archive/x", AccessTier: "Hot", Labels: Labels{"policy_violation": "false"}},
+		},
+		want: []Issue{
+			{SeverityError, `rule 2 has invalid content type pattern "image/["`},
+			{SeverityError, "rule 2 never matches, minSize is not less than maxSize"},
+		},
+	}, {
+		name:               "drop rules",
+		labelsWithDefaults: Labels{"tenant": "other"},
+		rules: []AggregationRule{
+			{Pattern: NewReGroup(`^(blob-inventory|\$logs)(/|$)`), Drop: true, StaticLabels: Labels{"tenant": "none"}},
+			{Pattern: NewReGroup(`^tenants/(?P<tenant>[^/]+)`)},
+		},
+		tests: []RuleTest{
+			{Dir: "$logs/blob/2024", Excluded: true},
+			{Dir: "tenants/foo", Labels: Labels{"tenant": "foo"}},
+			{Dir: "blob-inventory/2024", Labels: Labels{"tenant": "other"}},
+			{Dir: "tenants/bar", Excluded: true},
+		},
+		want: []Issue{
+			{SeverityWarning, "rule 0 drops the data it matches, so its labels are ignored"},
+			{SeverityError, "test 2 (blob-inventory/2024): excluded is true, expected false"},
+			{SeverityError, "test 3 (tenants/bar): excluded is false, expected true"},
+		},
+	}, {
+		name:               "globs and prefixes",
+		labelsWithDefaults: Labels{"tenant": "other", "type": "other"},
+		rules: []AggregationRule{
+			{Prefixes: NewPrefixMap(map[string]Labels{"tenants/acme": {"tenant": "acme"}})},
+			{Glob: NewGlob("tenants/{tenant}/**"), StaticLabels: Labels{"type": "tenant"}},
+			{Glob: NewGlob("data/*/{tenant}/**"), Pattern: NewReGroup(`^data/`)},
+			{Glob: NewGlob("tenants/acme/**")},
+			{},
+		},
+		tests: []RuleTest{
+			{Dir: "tenants/acme/data", Labels: Labels{"tenant": "acme", "type": "other"}},
+			{Dir: "tenants/globex", Labels: Labels{"tenant": "globex", "type": "tenant"}},
+		},
+		want: []Issue{
+			{SeverityError, "rule 2 has more than one of pattern, glob and prefixes"},
+			{SeverityError, "rule 4 has no pattern, glob or prefixes"},
+			{SeverityWarning, `rule 3 is probably unreachable, all example dirs like "tenants/acme" are matched by earlier rules (e.g. rule 0)`},
+		},
+	}, {
+		name:               "label templates",
+		labelsWithDefaults: Labels{"container": "", "dataset": "none", "project": "{{.container}}-{{.dataset}}", "owner": "{{.team | upper}}"},
+		rules: []AggregationRule{
+			{Pattern: NewReGroup(`^(?P<container>[^/]+)/(?P<team>[^/]+)/(?P<dataset>[^/]+)`)},
+			{Pattern: NewReGroup(`^(?P<container>[^/]+)/(?P<team>[^/]+)`), StaticLabels: Labels{"project": "{{.container}}-{{.team}}-all", "dataset": "{{.dataset"}},
+		},
+		tests: []RuleTest{
+			{Dir: "c/t/d", Labels: Labels{"container": "c", "dataset": "d", "project": "c-d", "owner": "T"}},
+			{Dir: "c/t", Labels: Labels{"container": "c", "dataset": "none", "project": "c-t-all", "owner": "T"}},
+			{Dir: "c", Labels: Labels{"container": "", "project": "-none", "owner": ""}},
+		},
+		want: []Issue{
+			{SeverityError, `rule 1 static label "dataset" has an invalid template: template: label:1: unclosed action`},
+		},
+	}, {
+		name:               "failing test",
+		labelsWithDefaults: Labels{"level1": "default1"},
+		rules: []AggregationRule{
+			{Pattern: NewReGroup(`^(?P<level1>[^/]+)/`)},
+		},
+		tests: []RuleTest{
+			{Dir: "dir1", Labels: Labels{"level1": "dir1"}},
+		},
+		want: []Issue{
+			{SeverityError, `test 0 (dir1): label "level1" is "default1", expected "dir1"`},
+		},
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Validate(tt.labelsWithDefaults, tt.rules, tt.tests)
+			assert.Equal(t, tt.want, got)
+			assert.Equal(t, HasErrors(tt.want), HasErrors(got))
+		})
+	}
+}
+
+func TestGetAttributeKeys(t *testing.T) {
+	tagKeys, metadataKeys := GetAttributeKeys([]AggregationRule{
+		{TagLabels: Labels{"owner": "owner", "team": "owner"}},
+		{TagLabels: Labels{"project": "project"}, MetadataLabels: Labels{"project": "Project"}},
+	})
+	assert.Equal(t, []string{"owner", "project"}, tagKeys)
+	assert.Equal(t, []string{"Project"}, metadataKeys)
+}
+
+func TestGetGrouping(t *testing.T) {
+	grouping := GetGrouping([]AggregationRule{
+		{Match: &RuleConditions{BlobTypes: []string{"BlockBlob"}, MinSize: byteSizePtr(1 << 20), MinAgeDays: int64Ptr(90)}},
+		{Match: &RuleConditions{MaxSize: byteSizePtr(1024), MinAgeDays: int64Ptr(90), MaxAgeDays: int64Ptr(7)}},
+		{},
+	})
+	assert.Equal(t, du.Grouping{BlobType: true, SizeThresholds: []du.StorageUsage{1024, 1 << 20}, AgeDaysThresholds: []int64{7, 90}}, grouping)
+}
+
+func int64Ptr(i int64) *int64 {
+	return &i
+}
+
+func byteSizePtr(b du.ByteSize) *du.ByteSize {
+	return &b
+}
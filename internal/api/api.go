@@ -0,0 +1,275 @@
+// Package api exposes the aggregation results and run history as a JSON REST API
+package api
+
+import (
+	"cmp"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/PDOK/azure-storage-usage-exporter/internal/agg"
+	"github.com/PDOK/azure-storage-usage-exporter/internal/du"
+	"github.com/PDOK/azure-storage-usage-exporter/internal/metrics"
+	"github.com/PDOK/azure-storage-usage-exporter/internal/report"
+)
+
+const (
+	pathPrefix  = "/api/v1"
+	familyParam = "metric"
+)
+
+// RunsResponse is the (stable) JSON representation of /api/v1/runs
+type RunsResponse struct {
+	Runs []RunEntry `json:"runs"`
+}
+
+// RunEntry is the (stable) JSON representation of a single inventory run
+type RunEntry struct {
+	RunDate time.Time         `json:"runDate"`
+	Rules   []string          `json:"rules"`
+	Status  metrics.RunStatus `json:"status"`
+}
+
+// ErrorResponse is the (stable) JSON representation of any error
+type ErrorResponse struct {
+	Error string `json:"error"`
+}
+
+type API struct {
+	updater *metrics.Updater
+	duStore *du.Store
+}
+
+// NewAPI creates the API, duStore is optional
+func NewAPI(updater *metrics.Updater, duStore *du.Store) *API {
+	return &API{updater: updater, duStore: duStore}
+}
+
+// RegisterHandlers adds the API endpoints to the given mux
+func (a *API) RegisterHandlers(mux *http.ServeMux) {
+	mux.HandleFunc("GET "+pathPrefix+"/usage", a.handleUsage)
+	mux.HandleFunc("GET "+pathPrefix+"/usage/{metric}", a.handleUsage)
+	mux.HandleFunc("GET "+pathPrefix+"/runs", a.handleRuns)
+	mux.HandleFunc("GET "+pathPrefix+"/du", a.handleDu)
+	mux.HandleFunc("GET "+pathPrefix+"/top-blobs", a.handleTopBlobs)
+	mux.HandleFunc("GET "+pathPrefix+"/duplicates", a.handleDuplicates)
+	mux.HandleFunc("GET "+pathPrefix+"/duplicates/{metric}", a.handleDuplicates)
+	mux.HandleFunc("GET "+pathPrefix+"/coverage", a.handleCoverage)
+	mux.HandleFunc("GET "+pathPrefix+"/coverage/{metric}", a.handleCoverage)
+	mux.HandleFunc("GET /debug/rules", a.handleDebugRules)
+}
+
+// handleUsage serves the results of the last processed run, of the default or given metric.
+// Every query parameter filters on the label with that name, multiple values for the same label are OR-ed.
+// The deleted query parameter filters on the deleted flag.
+func (a *API) handleUsage(w http.ResponseWriter, r *http.Request) {
+	runDate, aggregationResults, found := a.updater.GetLastAggregationResults(familyName(r))
+	if !found {
+		writeError(w, http.StatusNotFound, "unknown metric: "+familyName(r))
+		return
+	}
+	if runDate.IsZero() {
+		writeError(w, http.StatusServiceUnavailable, "no run has been processed yet")
+		return
+	}
+	filtered, err := filterAggregationResults(aggregationResults, r.URL.Query())
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, report.NewUsage(runDate, filtered))
+}
+
+func (a *API) handleRuns(w http.ResponseWriter, _ *http.Request) {
+	runs, err := a.updater.GetRuns()
+	if err != nil {
+		log.Printf("listing runs failed: %s", err)
+		writeError(w, http.StatusBadGateway, "listing runs failed")
+		return
+	}
+	response := RunsResponse{Runs: make([]RunEntry, len(runs))}
+	for i, run := range runs {
+		response.Runs[i] = RunEntry{RunDate: run.Date, Rules: run.Rules, Status: run.Status}
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+// handleCoverage serves how much data each rule matched in the last processed run,
+// including the largest dirs that matched no rule
+func (a *API) handleCoverage(w http.ResponseWriter, r *http.Request) {
+	family, found := a.updater.GetFamily(familyName(r))
+	if !found {
+		writeError(w, http.StatusNotFound, "unknown metric: "+familyName(r))
+		return
+	}
+	runDate, _, _ := a.updater.GetLastAggregationResults(family.Name)
+	if runDate.IsZero() {
+		writeError(w, http.StatusServiceUnavailable, "no run has been processed yet")
+		return
+	}
+	writeJSON(w, http.StatusOK, report.NewCoverage(runDate, family.Aggregator.GetLastCoverage()))
+}
+
+// handleDu serves the usage of the direct children of the prefix query parameter
+func (a *API) handleDu(w http.ResponseWriter, r *http.Request) {
+	if a.duStore == nil {
+		writeError(w, http.StatusNotFound, "du store is not configured")
+		return
+	}
+	runDate, err := a.duStore.GetRunDate()
+	if err != nil {
+		log.Printf("reading du store failed: %s", err)
+		writeError(w, http.StatusInternalServerError, "reading du store failed")
+		return
+	}
+	if runDate.IsZero() {
+		writeError(w, http.StatusServiceUnavailable, "no run has been stored yet")
+		return
+	}
+	prefix := strings.Trim(r.URL.Query().Get("prefix"), "/")
+	children, err := a.duStore.ListChildren(prefix)
+	if err != nil {
+		log.Printf("reading du store failed: %s", err)
+		writeError(w, http.StatusInternalServerError, "reading du store failed")
+		return
+	}
+	writeJSON(w, http.StatusOK, report.NewDirListing(runDate, prefix, children))
+}
+
+// handleTopBlobs serves the largest blobs of the last processed run
+func (a *API) handleTopBlobs(w http.ResponseWriter, _ *http.Request) {
+	runDate, blobs, ok := a.updater.GetLastLargestBlobs()
+	if !ok {
+		writeError(w, http.StatusNotFound, "top blobs are not configured")
+		return
+	}
+	if runDate.IsZero() {
+		writeError(w, http.StatusServiceUnavailable, "no run has been processed yet")
+		return
+	}
+	writeJSON(w, http.StatusOK, report.NewTopBlobs(runDate, blobs))
+}
+
+// handleDuplicates serves the largest duplicate sets of the last processed run,
+// and the reclaimable bytes per aggregation group of the default or given metric
+func (a *API) handleDuplicates(w http.ResponseWriter, r *http.Request) {
+	if _, found := a.updater.GetFamily(familyName(r)); !found {
+		writeError(w, http.StatusNotFound, "unknown metric: "+familyName(r))
+		return
+	}
+	runDate, duplicates, aggregationResults, ok := a.updater.GetLastDuplicates(familyName(r))
+	if !ok {
+		writeError(w, http.StatusNotFound, "duplicates are not configured")
+		return
+	}
+	if runDate.IsZero() {
+		writeError(w, http.StatusServiceUnavailable, "no run has been analyzed yet")
+		return
+	}
+	writeJSON(w, http.StatusOK, report.NewDuplicates(runDate, duplicates, aggregationResults))
+}
+
+// handleDebugRules explains which rule (of the default or given metric) matches the dir query parameter.
+// Not part of the stable API.
+func (a *API) handleDebugRules(w http.ResponseWriter, r *http.Request) {
+	family, found := a.updater.GetFamily(cmp.Or(r.URL.Query().Get(familyParam), metrics.DefaultFamily))
+	if !found {
+		writeError(w, http.StatusNotFound, "unknown metric: "+r.URL.Query().Get(familyParam))
+		return
+	}
+	if !r.URL.Query().Has("dir") {
+		writeError(w, http.StatusBadRequest, "dir query parameter is required")
+		return
+	}
+	blob, err := parseExampleBlob(r.URL.Query())
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, family.Aggregator.Explain(blob))
+}
+
+// parseExampleBlob reads the dir and the (optional) blob attributes deleted, accessTier, blobType, contentType,
+// size and ageDays from the query parameters
+func parseExampleBlob(query url.Values) (agg.ExampleBlob, error) {
+	blob := agg.ExampleBlob{
+		Dir:         query.Get("dir"),
+		AccessTier:  query.Get("accessTier"),
+		BlobType:    query.Get("blobType"),
+		ContentType: query.Get("contentType"),
+	}
+	var err error
+	if blob.Deleted, err = strconv.ParseBool(cmp.Or(query.Get(agg.Deleted), "false")); err != nil {
+		return blob, err
+	}
+	if query.Has("size") {
+		size, err := du.ParseByteSize(query.Get("size"))
+		if err != nil {
+			return blob, err
+		}
+		blob.Size = &size
+	}
+	if query.Has("ageDays") {
+		ageDays, err := strconv.ParseInt(query.Get("ageDays"), 10, 64)
+		if err != nil {
+			return blob, err
+		}
+		blob.AgeDays = &ageDays
+	}
+	return blob, nil
+}
+
+// familyName returns the metric (family) name from the path, or the default
+func familyName(r *http.Request) string {
+	return cmp.Or(r.PathValue(familyParam), metrics.DefaultFamily)
+}
+
+func filterAggregationResults(aggregationResults []agg.AggregationResult, filters map[string][]string) ([]agg.AggregationResult, error) {
+	var deletedFilter []bool
+	for _, value := range filters[agg.Deleted] {
+		deleted, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, err
+		}
+		deletedFilter = append(deletedFilter, deleted)
+	}
+	filtered := make([]agg.AggregationResult, 0, len(aggregationResults))
+	for _, aggregationResult := range aggregationResults {
+		if matchesFilters(aggregationResult.AggregationGroup, filters, deletedFilter) {
+			filtered = append(filtered, aggregationResult)
+		}
+	}
+	return filtered, nil
+}
+
+func matchesFilters(aggregationGroup agg.AggregationGroup, filters map[string][]string, deletedFilter []bool) bool {
+	if len(deletedFilter) > 0 && !slices.Contains(deletedFilter, aggregationGroup.Deleted) {
+		return false
+	}
+	for label, values := range filters {
+		if label == agg.Deleted {
+			continue
+		}
+		if !slices.Contains(values, aggregationGroup.Labels[label]) {
+			return false
+		}
+	}
+	return true
+}
+
+func writeJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		log.Printf("writing response failed: %s", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, ErrorResponse{Error: message})
+}
@@ -0,0 +1,43 @@
+package api
+
+import (
+	"testing"
+
+	"github.com/PDOK/azure-storage-usage-exporter/internal/agg"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_filterAggregationResults(t *testing.T) {
+	aggregationResults := []agg.AggregationResult{
+		{AggregationGroup: agg.AggregationGroup{Labels: agg.Labels{"tenant": "a", "type": "x"}, Deleted: false}, StorageUsage: 300},
+		{AggregationGroup: agg.AggregationGroup{Labels: agg.Labels{"tenant": "a", "type": "x"}, Deleted: true}, StorageUsage: 200},
+		{AggregationGroup: agg.AggregationGroup{Labels: agg.Labels{"tenant": "b", "type": "y"}, Deleted: false}, StorageUsage: 100},
+	}
+	tests := []struct {
+		name      string
+		filters   map[string][]string
+		wantUsage []int64
+		wantErr   bool
+	}{
+		{name: "no filters", filters: nil, wantUsage: []int64{300, 200, 100}},
+		{name: "single label", filters: map[string][]string{"tenant": {"a"}}, wantUsage: []int64{300, 200}},
+		{name: "or-ed values", filters: map[string][]string{"tenant": {"a", "b"}, "type": {"y"}}, wantUsage: []int64{100}},
+		{name: "deleted", filters: map[string][]string{"deleted": {"true"}}, wantUsage: []int64{200}},
+		{name: "unknown label", filters: map[string][]string{"nope": {"a"}}, wantUsage: []int64{}},
+		{name: "invalid deleted", filters: map[string][]string{"deleted": {"maybe"}}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := filterAggregationResults(aggregationResults, tt.filters)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			gotUsage := make([]int64, len(got))
+			for i := range got {
+				gotUsage[i] = got[i].StorageUsage
+			}
+			assert.Equal(t, tt.wantUsage, gotUsage)
+		})
+	}
+}
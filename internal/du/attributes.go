@@ -0,0 +1,68 @@
+package du
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/marcboeker/go-duckdb"
+)
+
+const (
+	// TagsColumn is the inventory field with the blob index tags
+	TagsColumn = "Tags"
+	// MetadataColumn is the inventory field with the blob metadata
+	MetadataColumn = "Metadata"
+)
+
+// Attributes are (selected) blob index tags or metadata, by key. Missing keys are left out.
+type Attributes map[string]string
+
+// Scan implements sql.Scanner, because duckdb maps are scanned as duckdb.Map
+func (a *Attributes) Scan(src any) error {
+	var m duckdb.Map
+	if err := m.Scan(src); err != nil {
+		return err
+	}
+	var attributes Attributes
+	for key, value := range m {
+		k, ok := key.(string)
+		if !ok {
+			return fmt.Errorf("unexpected attribute key type %T", key)
+		}
+		switch v := value.(type) {
+		case nil:
+			continue
+		case string:
+			if attributes == nil {
+				attributes = make(Attributes, len(m))
+			}
+			attributes[k] = v
+		default:
+			return fmt.Errorf("unexpected attribute value type %T", value)
+		}
+	}
+	*a = attributes
+	return nil
+}
+
+// attributesSelect returns an SQL select expression (including leading comma) for a map with the values of the given keys,
+// taken from a JSON object in the column (of inventory i), and the expressions to group by.
+// It returns nothing when there are no keys.
+func attributesSelect(columns []string, column string, alias string, keys []string) (string, []string) {
+	if len(keys) == 0 {
+		return "", nil
+	}
+	quotedKeys := make([]string, len(keys))
+	values := make([]string, len(keys))
+	for i, key := range keys {
+		quotedKeys[i] = SQLString(key)
+		values[i] = "NULL::VARCHAR"
+		if slices.Contains(columns, column) {
+			// the inventory has the tags and metadata as JSON objects
+			values[i] = fmt.Sprintf(`CASE WHEN json_valid(i."%s") THEN json_extract_string(i."%s", %s) END`,
+				column, column, SQLString(`$."`+strings.ReplaceAll(key, `"`, `\"`)+`"`))
+		}
+	}
+	return fmt.Sprintf(", MAP([%s], [%s]) as %s", strings.Join(quotedKeys, ", "), strings.Join(values, ", "), alias), values
+}
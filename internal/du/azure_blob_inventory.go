@@ -42,12 +42,17 @@ func (c *AzureBlobInventoryReportConfig) UnmarshalYAML(unmarshal func(any) error
 
 type AzureBlobInventoryReportDuReader struct {
 	config AzureBlobInventoryReportConfig
+	// sizeBucketBounds are the (inclusive) upper bounds of the size buckets, nil means no size buckets
+	sizeBucketBounds []int64
+	// tagKeys and metadataKeys are the blob index tags and metadata to group by
+	tagKeys      []string
+	metadataKeys []string
+	grouping     Grouping
 }
 
 type rulesRanByDate = map[time.Time][]string
 
 const (
-	runDatePathFormat  = "2006/01/02/15-04-05"
 	maxSaneCountDuRows = 10000000 // 10 million. if breached, maybe adapt duDepth
 	duDepth            = 4        // aggregate blob usage 4 dirs deep
 )
@@ -62,6 +67,26 @@ func NewAzureBlobInventoryReportDuReader(config AzureBlobInventoryReportConfig)
 	}
 }
 
+// WithSizeBuckets makes the reader count the blobs per size bucket, given the (inclusive) upper bounds of the buckets in ascending order
+func (ar *AzureBlobInventoryReportDuReader) WithSizeBuckets(upperBounds []int64) *AzureBlobInventoryReportDuReader {
+	ar.sizeBucketBounds = upperBounds
+	return ar
+}
+
+// WithAttributes makes the reader group by the values of the given blob index tags and metadata keys,
+// which the inventory must include (the Tags and Metadata fields)
+func (ar *AzureBlobInventoryReportDuReader) WithAttributes(tagKeys, metadataKeys []string) *AzureBlobInventoryReportDuReader {
+	ar.tagKeys = tagKeys
+	ar.metadataKeys = metadataKeys
+	return ar
+}
+
+// WithGrouping makes the reader also group by (classes of) blob attributes
+func (ar *AzureBlobInventoryReportDuReader) WithGrouping(grouping Grouping) *AzureBlobInventoryReportDuReader {
+	ar.grouping = grouping
+	return ar
+}
+
 func (ar *AzureBlobInventoryReportDuReader) TestConnection() error {
 	blobClient, err := ar.newBlobClient()
 	if err != nil {
@@ -105,21 +130,32 @@ func (ar *AzureBlobInventoryReportDuReader) Read(previousRunDate time.Time) (tim
 	}
 	log.Printf("found newest inventory run: %s", runDate)
 
-	log.Print("setting up duckdb, including azure blob store connection")
-	db, err := sqlx.Connect("duckdb", "")
+	rowsReceiver, errReceiver, err := ar.read(runDate)
+	return runDate, rowsReceiver, errReceiver, err
+}
+
+func (ar *AzureBlobInventoryReportDuReader) ReadRun(runDate time.Time) (<-chan Row, <-chan error, error) {
+	rulesRanByDate, err := ar.findRuns()
 	if err != nil {
-		return runDate, nil, nil, err
+		return nil, nil, err
+	}
+	if _, exists := rulesRanByDate[runDate]; !exists {
+		return nil, nil, fmt.Errorf("no inventory run found for %s", runDate.Format(RunDateFormat))
 	}
-	err = ar.initDB(db)
+	return ar.read(runDate)
+}
+
+func (ar *AzureBlobInventoryReportDuReader) read(runDate time.Time) (<-chan Row, <-chan error, error) {
+	db, err := ar.connect()
 	if err != nil {
-		return runDate, nil, nil, err
+		return nil, nil, err
 	}
 
 	rowsReceiver := make(chan Row, maxSaneCountDuRows/100)
 	errReceiver := make(chan error)
 	go ar.readRowsFromInventoryReport(runDate, db, rowsReceiver, errReceiver)
 
-	return runDate, rowsReceiver, errReceiver, nil
+	return rowsReceiver, errReceiver, nil
 }
 
 // readRowsFromInventoryReport coarsely aggregates the inventory reports parquet output with duckdb,
@@ -128,18 +164,39 @@ func (ar *AzureBlobInventoryReportDuReader) readRowsFromInventoryReport(runDate
 	defer close(rowsCh)
 	defer close(errCh)
 
+	parquetWildcardPath := ar.parquetWildcardPath(runDate)
+	columns, err := getInventoryColumns(db, parquetWildcardPath)
+	if err != nil {
+		errCh <- err
+		return
+	}
+
+	for _, attributes := range []struct {
+		column string
+		keys   []string
+	}{{TagsColumn, ar.tagKeys}, {MetadataColumn, ar.metadataKeys}} {
+		if len(attributes.keys) > 0 && !slices.Contains(columns, attributes.column) {
+			log.Printf("warning: the inventory doesn't include the %s field, labels from it will fall back to rules", attributes.column)
+		}
+	}
+	tagsSelect, tagsGroupBy := attributesSelect(columns, TagsColumn, "tags", ar.tagKeys)
+	metadataSelect, metadataGroupBy := attributesSelect(columns, MetadataColumn, "metadata", ar.metadataKeys)
+	groupingSelect, groupingGroupBy := ar.grouping.groupingSelect(columns, runDate)
+	groupBy := slices.Concat([]string{"dir", "deleted", "access_tier"}, tagsGroupBy, metadataGroupBy, groupingGroupBy)
+
 	// language=sql
-	duQuery := `
+	duQuery := fmt.Sprintf(`
 	SELECT array_to_string(string_split(i.Name, '/')[1:-2][1:?], '/') as dir, -- it's ar 1-based index; inclusive boundaries; :-2 strips the filename
 		   i."Deleted" as deleted,
+		   %s as access_tier,
 		   sum(i."Content-Length") as bytes,
 		   count(*) as cnt
-	FROM read_parquet([?]) i
-	GROUP BY dir, deleted
+		   %s %s %s %s
+	FROM read_parquet([?], union_by_name = true) i
+	GROUP BY %s
 	ORDER BY bytes DESC
 	LIMIT ? -- sanity limit
-	`
-	parquetWildcardPath := fmt.Sprintf("az://%s/%s/%s/*.parquet", ar.config.BlobInventoryContainer, runDate.Format(runDatePathFormat), "*")
+	`, ColumnOrDefault(columns, "AccessTier", "''"), sizeBucketsSelect(ar.sizeBucketBounds), tagsSelect, metadataSelect, groupingSelect, strings.Join(groupBy, ", "))
 
 	log.Print("start querying blob inventory (might take a while)")
 	dbRows, err := db.Queryx(duQuery, duDepth, parquetWildcardPath, maxSaneCountDuRows) //nolint:sqlclosecheck // it's closed 5 lines down
@@ -166,6 +223,47 @@ func (ar *AzureBlobInventoryReportDuReader) readRowsFromInventoryReport(runDate
 	log.Printf("done querying blob inventory, %d disk usage rows processed", i)
 }
 
+func (ar *AzureBlobInventoryReportDuReader) parquetWildcardPath(runDate time.Time) string {
+	return fmt.Sprintf("az://%s/%s/%s/*.parquet", ar.config.BlobInventoryContainer, runDate.Format(RunDateFormat), "*")
+}
+
+// sizeBucketsSelect returns an SQL select expression (including leading comma) for the counts per size bucket,
+// or nothing when there are no bounds
+func sizeBucketsSelect(upperBounds []int64) string {
+	if len(upperBounds) == 0 {
+		return ""
+	}
+	counts := make([]string, 0, len(upperBounds)+1)
+	lowerBound := int64(-1)
+	for _, upperBound := range upperBounds {
+		counts = append(counts, fmt.Sprintf(`count(*) FILTER (WHERE i."Content-Length" > %d AND i."Content-Length" <= %d)`, lowerBound, upperBound))
+		lowerBound = upperBound
+	}
+	counts = append(counts, fmt.Sprintf(`count(*) FILTER (WHERE i."Content-Length" > %d)`, lowerBound))
+	return fmt.Sprintf(", [%s]::BIGINT[] as size_buckets", strings.Join(counts, ", "))
+}
+
+// getInventoryColumns returns the names of the columns (fields) that the inventory rules include
+func getInventoryColumns(db *sqlx.DB, parquetPath string) ([]string, error) {
+	var columns []string
+	// language=sql
+	err := db.Select(&columns, `SELECT DISTINCT name FROM parquet_schema(?)`, parquetPath)
+	return columns, err
+}
+
+func (ar *AzureBlobInventoryReportDuReader) connect() (*sqlx.DB, error) {
+	log.Print("setting up duckdb, including azure blob store connection")
+	db, err := sqlx.Connect("duckdb", "")
+	if err != nil {
+		return nil, err
+	}
+	if err = ar.initDB(db); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
 func (ar *AzureBlobInventoryReportDuReader) initDB(db *sqlx.DB) error {
 	// language=sql
 	azInitQuery := `INSTALL azure;
@@ -208,7 +306,7 @@ func (ar *AzureBlobInventoryReportDuReader) findRuns() (rulesRanByDate, error) {
 			if err != nil { // no match
 				continue
 			}
-			runDate, err := time.Parse(runDatePathFormat, g["date"])
+			runDate, err := time.Parse(RunDateFormat, g["date"])
 			if err != nil { // unexpected
 				return nil, err
 			}
@@ -218,6 +316,23 @@ func (ar *AzureBlobInventoryReportDuReader) findRuns() (rulesRanByDate, error) {
 	return rulesRanByDate, nil
 }
 
+func (ar *AzureBlobInventoryReportDuReader) ListRuns() ([]Run, error) {
+	rulesRanByDate, err := ar.findRuns()
+	if err != nil {
+		return nil, err
+	}
+	runs := make([]Run, 0, len(rulesRanByDate))
+	for runDate, rules := range rulesRanByDate {
+		rules = slices.Clone(rules)
+		slices.Sort(rules)
+		runs = append(runs, Run{Date: runDate, Rules: slices.Compact(rules)})
+	}
+	slices.SortFunc(runs, func(a, b Run) int {
+		return b.Date.Compare(a.Date)
+	})
+	return runs, nil
+}
+
 func getLastRunDate(rulesRanByDate rulesRanByDate) (runDate time.Time, ok bool) {
 	dates := maps.Keys(rulesRanByDate)
 	if len(dates) == 0 {
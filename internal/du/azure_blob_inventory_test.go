@@ -0,0 +1,100 @@
+package du
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_sizeBucketsSelect(t *testing.T) {
+	db, err := sqlx.Connect("duckdb", "")
+	require.Nil(t, err)
+	defer db.Close()
+
+	var rows []Row
+	// language=sql
+	err = db.Select(&rows, `SELECT '' as dir, count(*) as cnt`+sizeBucketsSelect([]int64{4096, 1 << 20})+`
+		FROM read_parquet('../../example/blob-inventory/2024/04/18/*/*/*.parquet') i`)
+	require.Nil(t, err)
+	require.Len(t, rows, 1)
+	require.Len(t, rows[0].SizeBuckets, 3)
+	var total int64
+	for _, count := range rows[0].SizeBuckets {
+		total += count
+	}
+	assert.Equal(t, rows[0].Count, total)
+
+	assert.Empty(t, sizeBucketsSelect(nil))
+}
+
+func Test_attributesSelect(t *testing.T) {
+	db, err := sqlx.Connect("duckdb", "")
+	require.Nil(t, err)
+	defer db.Close()
+
+	columns := []string{"Name", "Tags"}
+	tagsSelect, tagsGroupBy := attributesSelect(columns, TagsColumn, "tags", []string{"owner", "it's"})
+	metadataSelect, metadataGroupBy := attributesSelect(columns, MetadataColumn, "metadata", []string{"project"})
+	var rows []Row
+	// language=sql
+	err = db.Select(&rows, `SELECT any_value(i.Name) as dir, count(*) as cnt`+tagsSelect+metadataSelect+` FROM (VALUES
+		('a', '{"owner": "team-a", "it''s": "quoted"}'),
+		('b', '{"owner": "team-a", "it''s": "quoted"}'),
+		('c', '{"other": "x"}'),
+		('d', 'not json'),
+		('e', NULL)
+	) i(Name, Tags) GROUP BY `+strings.Join(append(tagsGroupBy, metadataGroupBy...), ", ")+` ORDER BY cnt DESC`)
+	require.Nil(t, err)
+	require.Len(t, rows, 2)
+	assert.Nil(t, rows[0].Tags)
+	assert.Equal(t, int64(3), rows[0].Count)
+	assert.Equal(t, Attributes{"owner": "team-a", "it's": "quoted"}, rows[1].Tags)
+	assert.Equal(t, int64(2), rows[1].Count)
+	assert.Nil(t, rows[1].Metadata)
+
+	noSelect, noGroupBy := attributesSelect(columns, TagsColumn, "tags", nil)
+	assert.Empty(t, noSelect)
+	assert.Empty(t, noGroupBy)
+}
+
+func TestGrouping_groupingSelect(t *testing.T) {
+	db, err := sqlx.Connect("duckdb", "")
+	require.Nil(t, err)
+	defer db.Close()
+
+	runDate := time.Date(2024, 4, 18, 0, 0, 0, 0, time.UTC)
+	daysAgo := func(days int) int64 {
+		return runDate.AddDate(0, 0, -days).UnixMilli()
+	}
+	grouping := Grouping{BlobType: true, SizeThresholds: []StorageUsage{100}, AgeDaysThresholds: []int64{30}}
+	columns := []string{"Name", "BlobType", "Content-Length", "Last-Modified"}
+	groupingSelect, groupBy := grouping.groupingSelect(columns, runDate)
+	var rows []Row
+	// language=sql
+	err = db.Select(&rows, `SELECT count(*) as cnt`+groupingSelect+` FROM (VALUES
+		('a', 'BlockBlob', 10, ?),
+		('b', 'BlockBlob', 99, ?),
+		('c', 'BlockBlob', 100, ?),
+		('d', 'BlockBlob', 10, ?),
+		('e', 'AppendBlob', 10, ?)
+	) i(Name, BlobType, "Content-Length", "Last-Modified") GROUP BY `+strings.Join(groupBy, ", ")+` ORDER BY cnt DESC, min_size, min_age_days`,
+		daysAgo(1), daysAgo(29), daysAgo(2), daysAgo(30), daysAgo(40))
+	require.Nil(t, err)
+	require.Len(t, rows, 4)
+	assert.Equal(t, Row{Count: 2, BlobType: "BlockBlob", MinSize: int64Ptr(10), MaxSize: int64Ptr(99), MinAgeDays: int64Ptr(1), MaxAgeDays: int64Ptr(29)}, rows[0])
+	assert.Equal(t, Row{Count: 1, BlobType: "BlockBlob", MinSize: int64Ptr(10), MaxSize: int64Ptr(10), MinAgeDays: int64Ptr(30), MaxAgeDays: int64Ptr(30)}, rows[1])
+	assert.Equal(t, Row{Count: 1, BlobType: "AppendBlob", MinSize: int64Ptr(10), MaxSize: int64Ptr(10), MinAgeDays: int64Ptr(40), MaxAgeDays: int64Ptr(40)}, rows[2])
+	assert.Equal(t, Row{Count: 1, BlobType: "BlockBlob", MinSize: int64Ptr(100), MaxSize: int64Ptr(100), MinAgeDays: int64Ptr(2), MaxAgeDays: int64Ptr(2)}, rows[3])
+
+	noSelect, noGroupBy := Grouping{}.groupingSelect(columns, runDate)
+	assert.Empty(t, noSelect)
+	assert.Empty(t, noGroupBy)
+}
+
+func int64Ptr(i int64) *int64 {
+	return &i
+}
@@ -0,0 +1,50 @@
+package du
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var byteSizeRegex = regexp.MustCompile(`^\s*([0-9]+(?:\.[0-9]+)?)\s*([KMGTP]I?)?B?\s*$`)
+
+// ByteSize is a number of bytes, which can be configured as a plain number or with a unit (e.g. 500GB or 2TiB)
+type ByteSize StorageUsage
+
+func (b *ByteSize) UnmarshalYAML(unmarshal func(any) error) error {
+	var s string
+	if err := unmarshal(&s); err != nil {
+		return err
+	}
+	parsed, err := ParseByteSize(s)
+	if err != nil {
+		return err
+	}
+	*b = parsed
+	return nil
+}
+
+// ParseByteSize parses a number of bytes with an optional decimal (KB, MB, GB, TB, PB) or binary (KiB, MiB, GiB, TiB, PiB) unit
+func ParseByteSize(s string) (ByteSize, error) {
+	match := byteSizeRegex.FindStringSubmatch(strings.ToUpper(s))
+	if match == nil {
+		return 0, fmt.Errorf("invalid byte size: %q", s)
+	}
+	value, err := strconv.ParseFloat(match[1], 64)
+	if err != nil {
+		return 0, err
+	}
+	if unit := match[2]; unit != "" {
+		base := 1000.0
+		if strings.HasSuffix(unit, "I") {
+			base = 1024
+		}
+		value *= math.Pow(base, float64(strings.IndexByte("KMGTP", unit[0])+1))
+	}
+	if value > math.MaxInt64 {
+		return 0, fmt.Errorf("byte size too large: %q", s)
+	}
+	return ByteSize(value), nil
+}
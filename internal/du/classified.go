@@ -0,0 +1,64 @@
+package du
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// ClassifiedRow is a du row of blobs that were given the same (non-empty) class
+type ClassifiedRow struct {
+	Row
+	Class string `db:"class"`
+}
+
+// ClassifySQL returns an SQL expression on the blobs (inventory i) that results in a class, or NULL for no class.
+// The columns are the fields that the inventory includes.
+type ClassifySQL func(columns []string) string
+
+// ClassifiedRowsReader reads du rows of an inventory run, split by a class that's derived from each blob
+type ClassifiedRowsReader interface {
+	ReadClassifiedRows(runDate time.Time, classify ClassifySQL) ([]ClassifiedRow, error)
+}
+
+// ReadClassifiedRows groups the blobs that have a class by dir, access tier and class, in a separate duckdb session.
+// (Soft) deleted blobs are left out.
+func (ar *AzureBlobInventoryReportDuReader) ReadClassifiedRows(runDate time.Time, classify ClassifySQL) ([]ClassifiedRow, error) {
+	db, err := ar.connect()
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	return queryClassifiedRows(db, ar.parquetWildcardPath(runDate), classify)
+}
+
+func queryClassifiedRows(db *sqlx.DB, parquetPath string, classify ClassifySQL) ([]ClassifiedRow, error) {
+	columns, err := getInventoryColumns(db, parquetPath)
+	if err != nil {
+		return nil, err
+	}
+	// language=sql
+	classifiedQuery := fmt.Sprintf(`
+	SELECT array_to_string(string_split(i.Name, '/')[1:-2][1:?], '/') as dir,
+		   false as deleted,
+		   %s as access_tier,
+		   %s as class,
+		   sum(i."Content-Length") as bytes,
+		   count(*) as cnt
+	FROM read_parquet([?], union_by_name = true) i
+	WHERE NOT %s
+	GROUP BY dir, access_tier, class
+	HAVING class IS NOT NULL
+	ORDER BY bytes DESC
+	LIMIT ? -- sanity limit
+	`, ColumnOrDefault(columns, "AccessTier", "''"), classify(columns), ColumnOrDefault(columns, "Deleted", "false"))
+
+	log.Print("start querying classified blobs (might take a while)")
+	var rows []ClassifiedRow
+	if err = db.Select(&rows, classifiedQuery, duDepth, parquetPath, maxSaneCountDuRows); err != nil {
+		return nil, err
+	}
+	return rows, nil
+}
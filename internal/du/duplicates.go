@@ -0,0 +1,149 @@
+package du
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+const maxNamesPerDuplicateSet = 10
+
+// DuplicateSet is a group of blobs with the same content (Content-MD5 and size)
+type DuplicateSet struct {
+	MD5   string       `db:"md5"`
+	Size  StorageUsage `db:"size"`
+	Count int64        `db:"cnt"`
+	// ReclaimableBytes is what would be freed by keeping only one of the blobs
+	ReclaimableBytes StorageUsage `db:"reclaimable_bytes"`
+	// Names are (the first few, alphabetically) names of the blobs
+	Names stringList `db:"names"`
+}
+
+// Duplicates is the result of the duplicate content analysis of a run. (Soft) deleted blobs are not taken into account.
+type Duplicates struct {
+	// Sets are the duplicate sets with the most reclaimable bytes, largest first
+	Sets                  []DuplicateSet
+	TotalReclaimableBytes StorageUsage
+	// Rows are the reclaimable bytes (and count) per dir, access tier and the tags and metadata the reader is
+	// configured with (see WithAttributes), so they can be aggregated like du rows
+	// (but without the other blob attributes, like blob type, size and age).
+	// In every set the first blob (alphabetically) is considered the original, the others are reclaimable.
+	Rows []Row
+}
+
+// DuplicatesReader analyzes an inventory run for duplicate content
+type DuplicatesReader interface {
+	ReadDuplicates(runDate time.Time, n int) (Duplicates, error)
+}
+
+// ReadDuplicates groups the blobs by Content-MD5 and size, returns the n largest duplicate sets
+// and the reclaimable bytes per dir, access tier, tags and metadata. The inventory must include the Content-MD5 field.
+func (ar *AzureBlobInventoryReportDuReader) ReadDuplicates(runDate time.Time, n int) (Duplicates, error) {
+	db, err := ar.connect()
+	if err != nil {
+		return Duplicates{}, err
+	}
+	defer db.Close()
+	return queryDuplicates(db, ar.parquetWildcardPath(runDate), n, ar.tagKeys, ar.metadataKeys)
+}
+
+func queryDuplicates(db *sqlx.DB, parquetPath string, n int, tagKeys, metadataKeys []string) (Duplicates, error) {
+	columns, err := getInventoryColumns(db, parquetPath)
+	if err != nil {
+		return Duplicates{}, err
+	}
+	if !slices.Contains(columns, "Content-MD5") {
+		return Duplicates{}, errors.New("the inventory doesn't include the Content-MD5 field")
+	}
+	tagsSelect, _ := attributesSelect(columns, TagsColumn, "tags", tagKeys)
+	metadataSelect, _ := attributesSelect(columns, MetadataColumn, "metadata", metadataKeys)
+	groupBy := []string{"dir", "access_tier"}
+	var attributesCopy string
+	// the rows are grouped by the attribute maps of the copies, like the du rows are grouped by their values
+	for _, attributes := range []struct {
+		alias string
+		sql   string
+	}{{"tags", tagsSelect}, {"metadata", metadataSelect}} {
+		if attributes.sql != "" {
+			attributesCopy += ", " + attributes.alias
+			groupBy = append(groupBy, attributes.alias)
+		}
+	}
+	// language=sql
+	copiesQuery := fmt.Sprintf(`
+	CREATE TEMP TABLE copies AS
+	SELECT i.Name as name,
+		   %s as access_tier,
+		   i."Content-MD5" as md5,
+		   i."Content-Length" as size,
+		   row_number() OVER (PARTITION BY i."Content-MD5", i."Content-Length" ORDER BY i.Name) as copy_number,
+		   count(*) OVER (PARTITION BY i."Content-MD5", i."Content-Length") as cnt
+		   %s %s
+	FROM read_parquet([?], union_by_name = true) i
+	WHERE coalesce(i."Content-MD5", '') <> '' AND i."Content-Length" > 0 AND NOT %s
+	QUALIFY cnt > 1
+	`, ColumnOrDefault(columns, "AccessTier", "''"), tagsSelect, metadataSelect, ColumnOrDefault(columns, "Deleted", "false"))
+
+	// temp tables only exist in the connection (session) that created them, so use a single connection
+	ctx := context.Background()
+	conn, err := db.Connx(ctx)
+	if err != nil {
+		return Duplicates{}, err
+	}
+	defer conn.Close()
+	log.Print("start querying duplicate blobs (might take a while)")
+	if _, err = conn.ExecContext(ctx, copiesQuery, parquetPath); err != nil {
+		return Duplicates{}, err
+	}
+	defer conn.ExecContext(ctx, `DROP TABLE copies`) //nolint:errcheck // it's a temp table anyway
+
+	var duplicates Duplicates
+	// language=sql
+	setsQuery := fmt.Sprintf(`
+	SELECT md5, size, any_value(cnt) as cnt, (any_value(cnt) - 1) * size as reclaimable_bytes,
+		   list(name ORDER BY name)[1:%d] as names
+	FROM copies
+	GROUP BY md5, size
+	ORDER BY reclaimable_bytes DESC, md5
+	LIMIT ?
+	`, maxNamesPerDuplicateSet)
+	if err = conn.SelectContext(ctx, &duplicates.Sets, setsQuery, n); err != nil {
+		return Duplicates{}, err
+	}
+	// language=sql
+	rowsQuery := fmt.Sprintf(`
+	SELECT array_to_string(string_split(name, '/')[1:-2][1:?], '/') as dir,
+		   false as deleted,
+		   access_tier,
+		   sum(size) as bytes,
+		   count(*) as cnt
+		   %s
+	FROM copies
+	WHERE copy_number > 1
+	GROUP BY %s
+	ORDER BY bytes DESC
+	`, attributesCopy, strings.Join(groupBy, ", "))
+	if err = conn.SelectContext(ctx, &duplicates.Rows, rowsQuery, duDepth); err != nil {
+		return Duplicates{}, err
+	}
+	for _, row := range duplicates.Rows {
+		duplicates.TotalReclaimableBytes += row.Bytes
+	}
+	return duplicates, nil
+}
+
+// stringList is a []string that can be scanned from a duckdb list
+type stringList []string
+
+// Scan implements sql.Scanner
+func (s *stringList) Scan(src any) error {
+	list, err := scanList[string](src, "string list")
+	*s = list
+	return err
+}
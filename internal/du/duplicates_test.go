@@ -0,0 +1,45 @@
+package du
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_queryDuplicates(t *testing.T) {
+	db, err := sqlx.Connect("duckdb", "")
+	require.Nil(t, err)
+	defer db.Close()
+	db.SetMaxIdleConns(0) // every pool call gets a new connection (session)
+
+	parquetPath := filepath.Join(t.TempDir(), "inventory.parquet")
+	// language=sql
+	_, err = db.Exec(`COPY (SELECT * FROM (VALUES
+		('a/x/1.bin', 'md5-1', 100, false, 'Hot', NULL),
+		('b/y/1.bin', 'md5-1', 100, false, 'Hot', NULL),
+		('c/z/1.bin', 'md5-1', 100, false, 'Hot', NULL),
+		('a/x/2.bin', 'md5-2', 10, false, 'Hot', NULL),
+		('b/y/2.bin', 'md5-2', 10, true, 'Hot', NULL), -- deleted, so not a duplicate
+		('a/x/3.bin', 'md5-3', 50, false, 'Hot', NULL),
+		('b/y/3.bin', 'md5-3', 50, false, 'Cool', '{"owner": "b"}'),
+		('a/x/4.bin', NULL, 50, false, 'Hot', NULL),
+		('b/y/4.bin', NULL, 50, false, 'Hot', NULL)
+	) t(Name, "Content-MD5", "Content-Length", Deleted, AccessTier, Tags)) TO '` + parquetPath + `' (FORMAT parquet)`)
+	require.Nil(t, err)
+
+	duplicates, err := queryDuplicates(db, parquetPath, 10, []string{"owner"}, nil)
+	require.Nil(t, err)
+	assert.Equal(t, []DuplicateSet{
+		{MD5: "md5-1", Size: 100, Count: 3, ReclaimableBytes: 200, Names: stringList{"a/x/1.bin", "b/y/1.bin", "c/z/1.bin"}},
+		{MD5: "md5-3", Size: 50, Count: 2, ReclaimableBytes: 50, Names: stringList{"a/x/3.bin", "b/y/3.bin"}},
+	}, duplicates.Sets)
+	assert.Equal(t, StorageUsage(250), duplicates.TotalReclaimableBytes)
+	assert.ElementsMatch(t, []Row{
+		{Dir: "b/y", Deleted: boolPtr(false), AccessTier: "Hot", Bytes: 100, Count: 1},
+		{Dir: "b/y", Deleted: boolPtr(false), AccessTier: "Cool", Bytes: 50, Count: 1, Tags: Attributes{"owner": "b"}},
+		{Dir: "c/z", Deleted: boolPtr(false), AccessTier: "Hot", Bytes: 100, Count: 1},
+	}, duplicates.Rows)
+}
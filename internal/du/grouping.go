@@ -0,0 +1,52 @@
+package du
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Grouping are blob attributes that the du rows are (also) grouped by, so rules can match on them
+type Grouping struct {
+	BlobType    bool
+	ContentType bool
+	// SizeThresholds and AgeDaysThresholds (days since last modified, at the run date) split the rows,
+	// so that all blobs of a row are on the same side of each threshold
+	SizeThresholds    []StorageUsage
+	AgeDaysThresholds []int64
+}
+
+// groupingSelect returns SQL select expressions (including leading comma) for the grouping and the expressions to group by
+func (g Grouping) groupingSelect(columns []string, runDate time.Time) (string, []string) {
+	var selects, groupBy []string
+	if g.BlobType {
+		selects = append(selects, ColumnOrDefault(columns, "BlobType", "''")+" as blob_type")
+		groupBy = append(groupBy, "blob_type")
+	}
+	if g.ContentType {
+		selects = append(selects, ColumnOrDefault(columns, "Content-Type", "''")+" as content_type")
+		groupBy = append(groupBy, "content_type")
+	}
+	if len(g.SizeThresholds) > 0 {
+		selects = append(selects, `min(i."Content-Length") as min_size`, `max(i."Content-Length") as max_size`)
+		groupBy = append(groupBy, thresholdClass(`i."Content-Length"`, g.SizeThresholds))
+	}
+	if len(g.AgeDaysThresholds) > 0 {
+		age := AgeInDays(columns, "Last-Modified", runDate)
+		selects = append(selects, fmt.Sprintf("min(%s) as min_age_days", age), fmt.Sprintf("max(%s) as max_age_days", age))
+		groupBy = append(groupBy, thresholdClass(age, g.AgeDaysThresholds))
+	}
+	if len(selects) == 0 {
+		return "", nil
+	}
+	return ", " + strings.Join(selects, ", "), groupBy
+}
+
+// thresholdClass returns an SQL expression for the number of thresholds that the value reaches
+func thresholdClass(value string, thresholds []int64) string {
+	reached := make([]string, len(thresholds))
+	for i, threshold := range thresholds {
+		reached[i] = fmt.Sprintf("coalesce(%s >= %d, false)::INT", value, threshold)
+	}
+	return "(" + strings.Join(reached, " + ") + ")"
+}
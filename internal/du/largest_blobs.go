@@ -0,0 +1,65 @@
+package du
+
+import (
+	"fmt"
+	"log"
+	"slices"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Blob is a single blob from the inventory
+type Blob struct {
+	Name string       `db:"name"`
+	Size StorageUsage `db:"size"`
+	// AccessTier is empty when unknown
+	AccessTier string `db:"access_tier"`
+	// LastModified is nil when unknown
+	LastModified *time.Time `db:"last_modified"`
+	Deleted      bool       `db:"deleted"`
+}
+
+// LargestBlobsReader reads the largest individual blobs of an inventory run
+type LargestBlobsReader interface {
+	ReadLargestBlobs(runDate time.Time, n int) ([]Blob, error)
+}
+
+// ReadLargestBlobs queries the inventory for the n largest blobs, in a separate duckdb session
+func (ar *AzureBlobInventoryReportDuReader) ReadLargestBlobs(runDate time.Time, n int) ([]Blob, error) {
+	db, err := ar.connect()
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	return queryLargestBlobs(db, ar.parquetWildcardPath(runDate), n)
+}
+
+func queryLargestBlobs(db *sqlx.DB, parquetPath string, n int) ([]Blob, error) {
+	columns, err := getInventoryColumns(db, parquetPath)
+	if err != nil {
+		return nil, err
+	}
+	lastModified := "NULL"
+	if slices.Contains(columns, "Last-Modified") {
+		lastModified = `epoch_ms(i."Last-Modified")` // it's in epoch millis
+	}
+	// language=sql
+	largestBlobsQuery := fmt.Sprintf(`
+	SELECT i.Name as name,
+		   i."Content-Length" as size,
+		   %s as access_tier,
+		   %s as last_modified,
+		   %s as deleted
+	FROM read_parquet([?], union_by_name = true) i
+	ORDER BY size DESC, name
+	LIMIT ?
+	`, ColumnOrDefault(columns, "AccessTier", "''"), lastModified, ColumnOrDefault(columns, "Deleted", "false"))
+
+	log.Printf("start querying the %d largest blobs", n)
+	var blobs []Blob
+	if err = db.Select(&blobs, largestBlobsQuery, parquetPath, n); err != nil {
+		return nil, err
+	}
+	return blobs, nil
+}
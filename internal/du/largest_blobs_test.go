@@ -0,0 +1,25 @@
+package du
+
+import (
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_queryLargestBlobs(t *testing.T) {
+	db, err := sqlx.Connect("duckdb", "")
+	require.Nil(t, err)
+	defer db.Close()
+
+	blobs, err := queryLargestBlobs(db, "../../example/blob-inventory/2024/04/18/*/*/*.parquet", 2)
+	require.Nil(t, err)
+	require.Len(t, blobs, 2)
+	assert.Equal(t, StorageUsage(513863680), blobs[0].Size)
+	assert.Equal(t, "Y2U0ZWI1Zjc3OD/MWMyZTk1NDEyOTFkNmE3MWVkZ/YTY5ZDE4OTg4/NzQ5NDljOTBiZGYzYWYz/ZD/Nm/NWU3ZTMxNTdhZTEzMjA3ZTM1MTY2YWU1YmZmNzJiMGY=", blobs[0].Name)
+	require.NotNil(t, blobs[0].LastModified)
+	assert.Equal(t, int64(1712628209), blobs[0].LastModified.Unix())
+	assert.False(t, blobs[0].Deleted)
+	assert.GreaterOrEqual(t, blobs[0].Size, blobs[1].Size)
+}
@@ -0,0 +1,63 @@
+package du
+
+// DepthProfile describes the dir names at a single depth (1 is the container) of the stored du rows
+type DepthProfile struct {
+	Depth int `db:"depth"`
+	// Distinct is the number of distinct dir names at this depth
+	Distinct int64 `db:"distinct_names"`
+	// DistinctPrefixes is the number of distinct dirs up to this depth (so combinations of names)
+	DistinctPrefixes int64 `db:"distinct_prefixes"`
+	// Bytes is the usage of the dirs that are at least this deep, so the bytes that a label at this depth explains
+	Bytes StorageUsage `db:"bytes"`
+	// Largest are the names at this depth with the most bytes, largest first
+	Largest []NameUsage `db:"-"`
+}
+
+// NameUsage is the usage of all dirs with a given name at a certain depth
+type NameUsage struct {
+	Name  string       `db:"name"`
+	Bytes StorageUsage `db:"bytes"`
+}
+
+// ProfileDepths profiles the dir names at each depth up to maxDepth (including deleted blobs),
+// with the n largest names per depth. It also returns the total bytes.
+func (s *Store) ProfileDepths(maxDepth int, n int) ([]DepthProfile, StorageUsage, error) {
+	var total StorageUsage
+	// language=sql
+	if err := s.db.Get(&total, `SELECT coalesce(sum(bytes), 0)::BIGINT FROM du_rows`); err != nil {
+		return nil, 0, err
+	}
+	// language=sql
+	query := `
+	WITH split AS (
+		SELECT string_split(dir, '/') AS names, bytes FROM du_rows WHERE dir <> ''
+	)
+	SELECT depth,
+	       count(DISTINCT names[depth]) FILTER (WHERE len(names) >= depth) AS distinct_names,
+	       count(DISTINCT array_to_string(names[1:depth], '/')) FILTER (WHERE len(names) >= depth) AS distinct_prefixes,
+	       coalesce(sum(bytes) FILTER (WHERE len(names) >= depth), 0)::BIGINT AS bytes
+	FROM range(1, ? + 1) AS depths(depth), split
+	GROUP BY depth
+	HAVING count(*) FILTER (WHERE len(names) >= depth) > 0
+	ORDER BY depth
+	`
+	var profiles []DepthProfile
+	if err := s.db.Select(&profiles, query, maxDepth); err != nil {
+		return nil, 0, err
+	}
+	// language=sql
+	largestQuery := `
+	SELECT string_split(dir, '/')[$1] AS name, sum(bytes)::BIGINT AS bytes
+	FROM du_rows
+	WHERE dir <> '' AND len(string_split(dir, '/')) >= $1
+	GROUP BY 1
+	ORDER BY 2 DESC, 1
+	LIMIT $2
+	`
+	for i := range profiles {
+		if err := s.db.Select(&profiles[i].Largest, largestQuery, profiles[i].Depth, n); err != nil {
+			return nil, 0, err
+		}
+	}
+	return profiles, total, nil
+}
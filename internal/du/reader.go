@@ -1,25 +1,89 @@
 // Package du is the link between cloud storage and du (disk usage) data
 package du
 
-import "time"
+import (
+	"fmt"
+	"time"
+)
 
 // StorageUsage is storage usage/size in bytes
 type StorageUsage = int64
 
 // Row is info about the aggregated size of a specific dir (or prefix if you will) in cloud storage
 type Row struct {
-	Dir     string       `db:"dir"`
-	Deleted *bool        `db:"deleted"`
-	Bytes   StorageUsage `db:"bytes"`
-	Count   int64        `db:"cnt"`
+	Dir     string `db:"dir"`
+	Deleted *bool  `db:"deleted"`
+	// AccessTier is empty when unknown (e.g. because the inventory doesn't include it)
+	AccessTier string       `db:"access_tier"`
+	Bytes      StorageUsage `db:"bytes"`
+	Count      int64        `db:"cnt"`
+	// SizeBuckets is the number of blobs per size bucket, nil unless the Reader is configured with size buckets
+	SizeBuckets SizeBuckets `db:"size_buckets"`
+	// Tags and Metadata are the values of the blob index tags and metadata keys the Reader is configured with,
+	// nil unless configured
+	Tags     Attributes `db:"tags"`
+	Metadata Attributes `db:"metadata"`
+	// BlobType and ContentType are empty when unknown or not grouped by, see Grouping
+	BlobType    string `db:"blob_type"`
+	ContentType string `db:"content_type"`
+	// MinSize and MaxSize are the sizes of the smallest and largest blob, MinAgeDays and MaxAgeDays are the
+	// (whole) days since the most and least recently modified blob was last modified, at the run date.
+	// Nil when unknown or not grouped by, see Grouping.
+	MinSize    *StorageUsage `db:"min_size"`
+	MaxSize    *StorageUsage `db:"max_size"`
+	MinAgeDays *int64        `db:"min_age_days"`
+	MaxAgeDays *int64        `db:"max_age_days"`
+}
+
+// SizeBuckets are blob counts per size bucket (not cumulative), the last bucket holds the blobs larger than the largest bound
+type SizeBuckets []int64
+
+// Scan implements sql.Scanner
+func (s *SizeBuckets) Scan(src any) error {
+	buckets, err := scanList[int64](src, "size buckets")
+	*s = buckets
+	return err
+}
+
+// scanList scans a duckdb list, because those are scanned as []any. A NULL list is nil.
+func scanList[T any](src any, name string) ([]T, error) {
+	if src == nil {
+		return nil, nil
+	}
+	values, ok := src.([]any)
+	if !ok {
+		return nil, fmt.Errorf("unexpected %s type %T", name, src)
+	}
+	list := make([]T, len(values))
+	for i, value := range values {
+		if list[i], ok = value.(T); !ok {
+			return nil, fmt.Errorf("unexpected %s element type %T", name, value)
+		}
+	}
+	return list, nil
+}
+
+// Run is a single (blob inventory) run, of which the data is available
+type Run struct {
+	Date time.Time
+	// Rules are the names of the inventory rules that produced output in this run
+	Rules []string
 }
 
 // Reader provides Row s from a cloud storage provider
 //
 // The runDate indicates the actuality of the data.
 // If there is no new data, the returned runDate will be the same and the channel nil.
+// Run dates are formatted as RunDateFormat in the inventory report (and in user input).
 type Reader interface {
 	Read(previousRunDate time.Time) (runDate time.Time, rows <-chan Row, errs <-chan error, err error)
+	// ReadRun provides Row s from a specific run, which must exist
+	ReadRun(runDate time.Time) (rows <-chan Row, errs <-chan error, err error)
+	// ListRuns lists the available runs, newest first
+	ListRuns() ([]Run, error)
 	TestConnection() error
 	GetStorageAccountName() string
 }
+
+// RunDateFormat is how a run date is represented, both in blob inventory report paths and user input
+const RunDateFormat = "2006/01/02/15-04-05"
@@ -0,0 +1,42 @@
+package du
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+const millisPerDay = 24 * 60 * 60 * 1000
+
+// ColumnOrDefault returns an SQL expression for the column (of inventory i), or the default when the inventory lacks it
+func ColumnOrDefault(columns []string, column string, defaultExpr string) string {
+	if !slices.Contains(columns, column) {
+		return defaultExpr
+	}
+	return fmt.Sprintf(`coalesce(i."%s", %s)`, column, defaultExpr)
+}
+
+// AgeInDays returns an SQL expression for the whole days (like Azure) between the time column (of inventory i)
+// and the run date, which is NULL when the inventory lacks the column
+func AgeInDays(columns []string, column string, runDate time.Time) string {
+	if !slices.Contains(columns, column) {
+		return "NULL::BIGINT"
+	}
+	// the inventory times are in epoch millis
+	return fmt.Sprintf(`((%d - i."%s") // %d)`, runDate.UnixMilli(), column, millisPerDay)
+}
+
+// SQLString returns the string as an SQL string literal
+func SQLString(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+// SQLStrings returns the strings as a comma separated list of SQL string literals (e.g. for IN)
+func SQLStrings(ss []string) string {
+	quoted := make([]string, len(ss))
+	for i, s := range ss {
+		quoted[i] = SQLString(s)
+	}
+	return strings.Join(quoted, ", ")
+}
@@ -0,0 +1,265 @@
+package du
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	_ "github.com/marcboeker/go-duckdb" // duckdb sql driver
+)
+
+const (
+	storeInsertBatchSize = 1000
+	// language=sql
+	storeDuRowsSchema = `(dir VARCHAR NOT NULL, deleted BOOLEAN, access_tier VARCHAR NOT NULL, bytes BIGINT NOT NULL, cnt BIGINT NOT NULL)`
+)
+
+// StoreConfig configures where du rows are kept after aggregation
+type StoreConfig struct {
+	// Path of the DuckDB database file, when empty the rows are only kept in memory
+	Path string `yaml:"path"`
+}
+
+// DirUsage is the usage of a single dir (prefix), split by deleted or not
+type DirUsage struct {
+	Dir          string       `db:"dir"`
+	Bytes        StorageUsage `db:"bytes"`
+	Count        int64        `db:"cnt"`
+	DeletedBytes StorageUsage `db:"deleted_bytes"`
+	DeletedCount int64        `db:"deleted_cnt"`
+}
+
+// Store keeps the du rows of the newest run in (embedded) DuckDB, so they can be browsed afterward
+type Store struct {
+	db *sqlx.DB
+}
+
+func NewStore(config StoreConfig) (*Store, error) {
+	db, err := sqlx.Connect("duckdb", config.Path)
+	if err != nil {
+		return nil, err
+	}
+	// language=sql
+	initQuery := `CREATE TABLE IF NOT EXISTS du_rows ` + storeDuRowsSchema + `;
+				  CREATE TABLE IF NOT EXISTS du_run (run_date TIMESTAMP NOT NULL);`
+	if _, err := db.Exec(initQuery); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	return &Store{db: db}, nil
+}
+
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// GetRunDate returns the date of the run of which the rows are stored, or the zero time if there is none
+func (s *Store) GetRunDate() (time.Time, error) {
+	var runDate time.Time
+	err := s.db.Get(&runDate, `SELECT run_date FROM du_run LIMIT 1`)
+	if errors.Is(err, sql.ErrNoRows) {
+		return time.Time{}, nil
+	}
+	return runDate, err
+}
+
+// ListChildren returns the usage of the direct children of the given prefix, largest first.
+// An empty Dir in the result means blobs directly in the prefix (or deeper than the du depth).
+func (s *Store) ListChildren(prefix string) ([]DirUsage, error) {
+	prefix = strings.Trim(prefix, "/")
+	// language=sql
+	query := `
+	WITH matching AS (
+		SELECT CASE WHEN ? = '' THEN dir
+		            WHEN dir = ? THEN ''
+		            ELSE substr(dir, length(?) + 2) END AS rest,
+		       coalesce(deleted, false) AS deleted,
+		       bytes,
+		       cnt
+		FROM du_rows
+		WHERE ? = '' OR dir = ? OR starts_with(dir, ? || '/')
+	)
+	SELECT split_part(rest, '/', 1) AS dir,
+	       coalesce(sum(bytes) FILTER (WHERE NOT deleted), 0)::BIGINT AS bytes,
+	       coalesce(sum(cnt) FILTER (WHERE NOT deleted), 0)::BIGINT AS cnt,
+	       coalesce(sum(bytes) FILTER (WHERE deleted), 0)::BIGINT AS deleted_bytes,
+	       coalesce(sum(cnt) FILTER (WHERE deleted), 0)::BIGINT AS deleted_cnt
+	FROM matching
+	GROUP BY 1
+	ORDER BY sum(bytes) DESC, 1 -- including deleted
+	`
+	var children []DirUsage
+	if err := s.db.Select(&children, query, prefix, prefix, prefix, prefix, prefix, prefix); err != nil {
+		return nil, err
+	}
+	for i := range children {
+		if children[i].Dir != "" && prefix != "" {
+			children[i].Dir = prefix + "/" + children[i].Dir
+		} else if children[i].Dir == "" {
+			children[i].Dir = prefix
+		}
+	}
+	return children, nil
+}
+
+// replace swaps the stored rows with the rows received, but only if all rows were received successfully
+func (s *Store) replace(runDate time.Time, rowsCh <-chan Row, successCh <-chan bool) error {
+	// language=sql
+	_, insertErr := s.db.Exec(`CREATE OR REPLACE TABLE du_rows_staging ` + storeDuRowsSchema)
+	batch := make([]Row, 0, storeInsertBatchSize)
+	for row := range rowsCh {
+		if insertErr != nil {
+			continue // keep draining, so the sender never blocks
+		}
+		batch = append(batch, row)
+		if len(batch) == storeInsertBatchSize {
+			insertErr = s.insertBatch(batch)
+			batch = batch[:0]
+		}
+	}
+	if insertErr == nil {
+		insertErr = s.insertBatch(batch)
+	}
+	if !<-successCh || insertErr != nil {
+		// language=sql
+		_, _ = s.db.Exec(`DROP TABLE IF EXISTS du_rows_staging`)
+		return insertErr
+	}
+
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+	// language=sql
+	swapQuery := `DROP TABLE du_rows;
+				  ALTER TABLE du_rows_staging RENAME TO du_rows;
+				  DELETE FROM du_run;`
+	if _, err = tx.Exec(swapQuery); err != nil {
+		return err
+	}
+	if _, err = tx.Exec(`INSERT INTO du_run VALUES (?)`, runDate); err != nil {
+		return err
+	}
+	if err = tx.Commit(); err != nil {
+		return err
+	}
+	log.Printf("stored du rows of run %s", runDate)
+	return nil
+}
+
+func (s *Store) insertBatch(batch []Row) error {
+	if len(batch) == 0 {
+		return nil
+	}
+	placeholders := make([]string, len(batch))
+	args := make([]any, 0, len(batch)*5)
+	for i, row := range batch {
+		placeholders[i] = "(?, ?, ?, ?, ?)"
+		args = append(args, row.Dir, row.Deleted, row.AccessTier, row.Bytes, row.Count)
+	}
+	query := fmt.Sprintf(`INSERT INTO du_rows_staging VALUES %s`, strings.Join(placeholders, ", "))
+	_, err := s.db.Exec(query, args...)
+	return err
+}
+
+// StoringReader is a Reader that also keeps the rows of the newest run in a Store
+type StoringReader struct {
+	Reader
+	store *Store
+	// storing tracks the runs that are still being stored
+	storing sync.WaitGroup
+}
+
+func NewStoringReader(reader Reader, store *Store) *StoringReader {
+	return &StoringReader{Reader: reader, store: store}
+}
+
+func (sr *StoringReader) Read(previousRunDate time.Time) (time.Time, <-chan Row, <-chan error, error) {
+	runDate, rowsCh, errCh, err := sr.Reader.Read(previousRunDate)
+	if err != nil || rowsCh == nil {
+		return runDate, rowsCh, errCh, err
+	}
+	teeRowsCh := make(chan Row, cap(rowsCh))
+	teeErrCh := make(chan error)
+	storeRowsCh := make(chan Row, storeInsertBatchSize)
+	storeSuccessCh := make(chan bool, 1)
+	sr.storing.Add(1)
+	go func() {
+		defer sr.storing.Done()
+		if err := sr.store.replace(runDate, storeRowsCh, storeSuccessCh); err != nil {
+			log.Printf("storing du rows failed: %s", err)
+		}
+	}()
+	go func() {
+		defer close(teeErrCh)
+		defer close(teeRowsCh)
+		success := drain(rowsCh, errCh, func(row Row) {
+			storeRowsCh <- row
+			teeRowsCh <- row
+		}, func(err error) {
+			teeErrCh <- err
+		})
+		close(storeRowsCh)
+		storeSuccessCh <- success
+	}()
+	return runDate, teeRowsCh, teeErrCh, nil
+}
+
+// Wait blocks until the rows of the runs that were read are stored (or discarded, when reading failed)
+func (sr *StoringReader) Wait() {
+	sr.storing.Wait()
+}
+
+// Load replaces the stored rows with the given rows (as provided by a Reader), blocking until done
+func (s *Store) Load(runDate time.Time, rowsCh <-chan Row, errCh <-chan error) error {
+	storeRowsCh := make(chan Row, storeInsertBatchSize)
+	storeSuccessCh := make(chan bool, 1)
+	var readErr error
+	go func() {
+		success := drain(rowsCh, errCh, func(row Row) {
+			storeRowsCh <- row
+		}, func(err error) {
+			readErr = err
+		})
+		close(storeRowsCh)
+		storeSuccessCh <- success
+	}()
+	if err := s.replace(runDate, storeRowsCh, storeSuccessCh); err != nil {
+		return err
+	}
+	return readErr // safe, because replace has received from storeSuccessCh
+}
+
+// drain reads rows and errors until both channels are closed, handling rows until the first error.
+// Returns whether no error was received.
+func drain(rowsCh <-chan Row, errCh <-chan error, handleRow func(Row), handleErr func(error)) bool {
+	success := true
+	for rowsCh != nil || errCh != nil {
+		select {
+		case err, ok := <-errCh:
+			if !ok {
+				errCh = nil
+				continue
+			}
+			if success {
+				success = false
+				handleErr(err)
+			}
+		case row, ok := <-rowsCh:
+			if !ok {
+				rowsCh = nil
+				continue
+			}
+			if success { // consumers stop reading after an error, so just drain after that
+				handleRow(row)
+			}
+		}
+	}
+	return success
+}
@@ -0,0 +1,137 @@
+package du
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStoringReader(t *testing.T) {
+	someFixedTime, _ := time.Parse(time.DateOnly, "2024-04-20")
+	rows := []Row{
+		{Dir: "container1/dir1/a", Deleted: boolPtr(false), Bytes: 100, Count: 10},
+		{Dir: "container1/dir1/a", Deleted: boolPtr(true), Bytes: 50, Count: 5},
+		{Dir: "container1/dir1", Deleted: boolPtr(false), Bytes: 7, Count: 1},
+		{Dir: "container1/dir2", Deleted: nil, Bytes: 300, Count: 3},
+		{Dir: "container2", Deleted: boolPtr(false), Bytes: 1, Count: 1},
+	}
+	store, err := NewStore(StoreConfig{})
+	require.Nil(t, err)
+	defer store.Close()
+
+	reader := NewStoringReader(&fakeReader{runDate: someFixedTime, rows: rows}, store)
+	consume(t, reader, someFixedTime.Add(-time.Hour))
+	reader.Wait()
+
+	children, err := store.ListChildren("")
+	require.Nil(t, err)
+	assert.Equal(t, []DirUsage{
+		{Dir: "container1", Bytes: 407, Count: 14, DeletedBytes: 50, DeletedCount: 5},
+		{Dir: "container2", Bytes: 1, Count: 1},
+	}, children)
+
+	children, err = store.ListChildren("/container1/dir1/")
+	require.Nil(t, err)
+	assert.Equal(t, []DirUsage{
+		{Dir: "container1/dir1/a", Bytes: 100, Count: 10, DeletedBytes: 50, DeletedCount: 5},
+		{Dir: "container1/dir1", Bytes: 7, Count: 1},
+	}, children)
+
+	// a failing run doesn't replace the stored rows
+	failingReader := NewStoringReader(&fakeReader{runDate: someFixedTime.Add(time.Hour), rows: rows[:1], fail: true}, store)
+	consume(t, failingReader, someFixedTime)
+	failingReader.Wait()
+	runDate, err := store.GetRunDate()
+	require.Nil(t, err)
+	assert.Equal(t, someFixedTime, runDate.UTC())
+}
+
+func consume(t *testing.T, reader Reader, previousRunDate time.Time) {
+	t.Helper()
+	_, rowsCh, errCh, err := reader.Read(previousRunDate)
+	require.Nil(t, err)
+	for rowsCh != nil || errCh != nil {
+		select {
+		case _, ok := <-rowsCh:
+			if !ok {
+				rowsCh = nil
+			}
+		case _, ok := <-errCh:
+			if !ok {
+				errCh = nil
+			}
+		}
+	}
+}
+
+type fakeReader struct {
+	runDate time.Time
+	rows    []Row
+	fail    bool
+}
+
+func (f *fakeReader) Read(_ time.Time) (time.Time, <-chan Row, <-chan error, error) {
+	rowsCh, errCh, err := f.ReadRun(f.runDate)
+	return f.runDate, rowsCh, errCh, err
+}
+
+func (f *fakeReader) ReadRun(_ time.Time) (<-chan Row, <-chan error, error) {
+	rowsCh := make(chan Row)
+	errCh := make(chan error)
+	go func() {
+		for _, row := range f.rows {
+			rowsCh <- row
+		}
+		if f.fail {
+			errCh <- errors.New("failed")
+		}
+		close(rowsCh)
+		close(errCh)
+	}()
+	return rowsCh, errCh, nil
+}
+
+func (f *fakeReader) ListRuns() ([]Run, error) {
+	return []Run{{Date: f.runDate}}, nil
+}
+
+func (f *fakeReader) TestConnection() error {
+	return nil
+}
+
+func (f *fakeReader) GetStorageAccountName() string {
+	return "faker"
+}
+
+func boolPtr(b bool) *bool {
+	return &b
+}
+
+func TestStore_ProfileDepths(t *testing.T) {
+	someFixedTime, _ := time.Parse(time.DateOnly, "2024-04-20")
+	store, err := NewStore(StoreConfig{})
+	require.Nil(t, err)
+	defer store.Close()
+	reader := &fakeReader{runDate: someFixedTime, rows: []Row{
+		{Dir: "container1/a/x", Deleted: boolPtr(false), Bytes: 100, Count: 10},
+		{Dir: "container1/a/y", Deleted: boolPtr(true), Bytes: 50, Count: 5},
+		{Dir: "container1/b", Deleted: boolPtr(false), Bytes: 30, Count: 1},
+		{Dir: "container1", Deleted: boolPtr(false), Bytes: 7, Count: 1},
+		{Dir: "container2/a", Deleted: nil, Bytes: 300, Count: 3},
+	}}
+	rowsCh, errCh, err := reader.ReadRun(someFixedTime)
+	require.Nil(t, err)
+	require.Nil(t, store.Load(someFixedTime, rowsCh, errCh))
+
+	profiles, total, err := store.ProfileDepths(5, 2)
+	require.Nil(t, err)
+	assert.Equal(t, StorageUsage(487), total)
+	assert.Equal(t, []DepthProfile{
+		{Depth: 1, Distinct: 2, DistinctPrefixes: 2, Bytes: 487, Largest: []NameUsage{{"container2", 300}, {"container1", 187}}},
+		{Depth: 2, Distinct: 2, DistinctPrefixes: 3, Bytes: 480, Largest: []NameUsage{{"a", 450}, {"b", 30}}},
+		{Depth: 3, Distinct: 2, DistinctPrefixes: 2, Bytes: 150, Largest: []NameUsage{{"x", 100}, {"y", 50}}},
+	}, profiles)
+}
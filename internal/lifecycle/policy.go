@@ -0,0 +1,216 @@
+// Package lifecycle simulates the effect of an Azure lifecycle management policy on an inventory run
+package lifecycle
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/PDOK/azure-storage-usage-exporter/internal/du"
+)
+
+// Action is what a lifecycle policy does with a blob, either moving it to a colder access tier or deleting it
+type Action string
+
+const (
+	ActionTierToCool    Action = "tierToCool"
+	ActionTierToCold    Action = "tierToCold"
+	ActionTierToArchive Action = "tierToArchive"
+	ActionDelete        Action = "delete"
+)
+
+// actionsBySeverity are the supported actions, when several apply to a blob the first one wins
+// (like Azure applies the least expensive action)
+var actionsBySeverity = []Action{ActionDelete, ActionTierToArchive, ActionTierToCold, ActionTierToCool}
+
+// targetTiers are the access tiers (as in the inventory) that the tier actions move blobs to
+var targetTiers = map[Action]string{
+	ActionTierToCool:    "Cool",
+	ActionTierToCold:    "Cold",
+	ActionTierToArchive: "Archive",
+}
+
+// fromTiers are the access tiers from which a tier action moves blobs (to a colder tier)
+var fromTiers = map[Action][]string{
+	ActionTierToCool:    {"Hot"},
+	ActionTierToCold:    {"Hot", "Cool"},
+	ActionTierToArchive: {"Hot", "Cool", "Cold"},
+}
+
+// TargetTier returns the access tier a blob ends up in, empty for ActionDelete
+func (a Action) TargetTier() string {
+	return targetTiers[a]
+}
+
+// Policy is (the supported subset of) an Azure lifecycle management policy,
+// as in the portal's code view or the output of `az storage account management-policy show`
+type Policy struct {
+	Rules []Rule `json:"rules"`
+}
+
+type Rule struct {
+	Name       string     `json:"name"`
+	Enabled    *bool      `json:"enabled"`
+	Type       string     `json:"type"`
+	Definition Definition `json:"definition"`
+}
+
+type Definition struct {
+	Filters Filters `json:"filters"`
+	Actions Actions `json:"actions"`
+}
+
+type Filters struct {
+	BlobTypes      []string          `json:"blobTypes"`
+	PrefixMatch    []string          `json:"prefixMatch"`
+	BlobIndexMatch []json.RawMessage `json:"blobIndexMatch"`
+}
+
+type Actions struct {
+	BaseBlob map[Action]Condition `json:"baseBlob"`
+	Snapshot json.RawMessage      `json:"snapshot"`
+	Version  json.RawMessage      `json:"version"`
+}
+
+// Condition is when an action applies, at least one of the days must be given
+type Condition struct {
+	DaysAfterModificationGreaterThan   *int `json:"daysAfterModificationGreaterThan"`
+	DaysAfterCreationGreaterThan       *int `json:"daysAfterCreationGreaterThan"`
+	DaysAfterLastAccessTimeGreaterThan *int `json:"daysAfterLastAccessTimeGreaterThan"`
+}
+
+// ParsePolicy parses a lifecycle policy, which may be wrapped in {"policy": ...}.
+// Parts of the policy that can't be simulated are returned as warnings.
+func ParsePolicy(data []byte) (Policy, []string, error) {
+	var wrapped struct {
+		Policy *Policy `json:"policy"`
+	}
+	if err := json.Unmarshal(data, &wrapped); err != nil {
+		return Policy{}, nil, err
+	}
+	policy := Policy{}
+	if wrapped.Policy != nil {
+		policy = *wrapped.Policy
+	} else if err := json.Unmarshal(data, &policy); err != nil {
+		return Policy{}, nil, err
+	}
+	if len(policy.Rules) == 0 {
+		return Policy{}, nil, errors.New("lifecycle policy has no rules")
+	}
+	var warnings []string
+	for i, rule := range policy.Rules {
+		name := rule.Name
+		if name == "" {
+			name = fmt.Sprintf("%d", i)
+		}
+		if len(rule.Definition.Filters.BlobIndexMatch) > 0 {
+			warnings = append(warnings, fmt.Sprintf("rule %s: blobIndexMatch filters are ignored", name))
+		}
+		if len(rule.Definition.Actions.Snapshot) > 0 || len(rule.Definition.Actions.Version) > 0 {
+			warnings = append(warnings, fmt.Sprintf("rule %s: snapshot and version actions are ignored", name))
+		}
+		for _, action := range sortedActions(rule.Definition.Actions.BaseBlob) {
+			condition := rule.Definition.Actions.BaseBlob[action]
+			if action != ActionDelete && action.TargetTier() == "" {
+				warnings = append(warnings, fmt.Sprintf("rule %s: action %s is ignored", name, action))
+			}
+			if condition.DaysAfterLastAccessTimeGreaterThan != nil {
+				warnings = append(warnings, fmt.Sprintf("rule %s: %s daysAfterLastAccessTimeGreaterThan is ignored", name, action))
+			}
+			if condition.DaysAfterModificationGreaterThan == nil && condition.DaysAfterCreationGreaterThan == nil {
+				return Policy{}, warnings, fmt.Errorf("rule %s: %s has no (supported) condition", name, action)
+			}
+		}
+	}
+	return policy, warnings, nil
+}
+
+// sortedActions returns the actions by severity, followed by the unsupported actions in lexical order
+func sortedActions(conditions map[Action]Condition) []Action {
+	var actions, unsupported []Action
+	for _, action := range actionsBySeverity {
+		if _, ok := conditions[action]; ok {
+			actions = append(actions, action)
+		}
+	}
+	for action := range conditions {
+		if !slices.Contains(actionsBySeverity, action) {
+			unsupported = append(unsupported, action)
+		}
+	}
+	slices.Sort(unsupported)
+	return append(actions, unsupported...)
+}
+
+// actionSQL returns an SQL expression (on inventory i, evaluated at the run date)
+// that results in the Action for each blob, or NULL when no action applies.
+// The inventory columns are needed because the inventory doesn't always include all fields.
+func (p Policy) actionSQL(columns []string, runDate time.Time) string {
+	var cases []string
+	for _, action := range actionsBySeverity {
+		var ruleConditions []string
+		for _, rule := range p.Rules {
+			if rule.Enabled != nil && !*rule.Enabled {
+				continue
+			}
+			condition, ok := rule.Definition.Actions.BaseBlob[action]
+			if !ok {
+				continue
+			}
+			conditions := append(rule.Definition.Filters.sql(columns), condition.sql(columns, runDate))
+			ruleConditions = append(ruleConditions, "("+strings.Join(conditions, " AND ")+")")
+		}
+		if len(ruleConditions) == 0 {
+			continue
+		}
+		when := "(" + strings.Join(ruleConditions, " OR ") + ")"
+		if action != ActionDelete {
+			when += fmt.Sprintf(" AND %s IN (%s)", du.ColumnOrDefault(columns, "AccessTier", "'Hot'"), du.SQLStrings(fromTiers[action]))
+		}
+		cases = append(cases, fmt.Sprintf("WHEN %s THEN %s", when, du.SQLString(string(action))))
+	}
+	if len(cases) == 0 {
+		return "NULL"
+	}
+	// base blob actions don't apply to snapshots and previous versions
+	notBaseBlob := fmt.Sprintf("WHEN %s <> '' OR NOT %s THEN NULL",
+		du.ColumnOrDefault(columns, "Snapshot", "''"), du.ColumnOrDefault(columns, "IsCurrentVersion", "true"))
+	return "CASE " + notBaseBlob + " " + strings.Join(cases, " ") + " END"
+}
+
+func (f Filters) sql(columns []string) []string {
+	conditions := []string{"true"}
+	if len(f.PrefixMatch) > 0 {
+		prefixConditions := make([]string, len(f.PrefixMatch))
+		for i, prefix := range f.PrefixMatch {
+			prefixConditions[i] = fmt.Sprintf("starts_with(i.Name, %s)", du.SQLString(prefix))
+		}
+		conditions = append(conditions, "("+strings.Join(prefixConditions, " OR ")+")")
+	}
+	if len(f.BlobTypes) > 0 {
+		blobTypes := make([]string, len(f.BlobTypes))
+		for i, blobType := range f.BlobTypes {
+			blobTypes[i] = strings.ToLower(blobType)
+		}
+		conditions = append(conditions, fmt.Sprintf("lower(%s) IN (%s)", du.ColumnOrDefault(columns, "BlobType", "'blockblob'"), du.SQLStrings(blobTypes)))
+	}
+	return conditions
+}
+
+func (c Condition) sql(columns []string, runDate time.Time) string {
+	var conditions []string
+	if c.DaysAfterModificationGreaterThan != nil {
+		conditions = append(conditions, fmt.Sprintf("%s > %d", du.AgeInDays(columns, "Last-Modified", runDate), *c.DaysAfterModificationGreaterThan))
+	}
+	if c.DaysAfterCreationGreaterThan != nil {
+		conditions = append(conditions, fmt.Sprintf("%s > %d", du.AgeInDays(columns, "Creation-Time", runDate), *c.DaysAfterCreationGreaterThan))
+	}
+	if len(conditions) == 0 {
+		return "false"
+	}
+	// Azure requires all conditions of an action to be met
+	return "coalesce(" + strings.Join(conditions, " AND ") + ", false)"
+}
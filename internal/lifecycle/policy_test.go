@@ -0,0 +1,135 @@
+package lifecycle
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testPolicy = `{
+  "policy": {
+    "rules": [
+      {
+        "enabled": true,
+        "name": "logs",
+        "type": "Lifecycle",
+        "definition": {
+          "filters": {"blobTypes": ["blockBlob"], "prefixMatch": ["logs/"]},
+          "actions": {
+            "baseBlob": {
+              "tierToCool": {"daysAfterModificationGreaterThan": 30},
+              "tierToArchive": {"daysAfterModificationGreaterThan": 90},
+              "delete": {"daysAfterCreationGreaterThan": 365}
+            }
+          }
+        }
+      },
+      {
+        "enabled": false,
+        "name": "disabled",
+        "type": "Lifecycle",
+        "definition": {
+          "filters": {"blobTypes": ["blockBlob"]},
+          "actions": {"baseBlob": {"delete": {"daysAfterModificationGreaterThan": 0}}}
+        }
+      },
+      {
+        "name": "backups",
+        "type": "Lifecycle",
+        "definition": {
+          "filters": {"blobTypes": ["blockBlob"], "prefixMatch": ["backups/it's"]},
+          "actions": {"baseBlob": {"tierToCold": {"daysAfterModificationGreaterThan": 7, "daysAfterLastAccessTimeGreaterThan": 7}}}
+        }
+      }
+    ]
+  }
+}`
+
+func TestParsePolicy(t *testing.T) {
+	policy, warnings, err := ParsePolicy([]byte(testPolicy))
+	require.Nil(t, err)
+	assert.Len(t, policy.Rules, 3)
+	assert.Equal(t, []string{"rule backups: tierToCold daysAfterLastAccessTimeGreaterThan is ignored"}, warnings)
+
+	_, _, err = ParsePolicy([]byte(`{"rules": []}`))
+	assert.ErrorContains(t, err, "no rules")
+	_, _, err = ParsePolicy([]byte(`{"rules": [{"name": "x", "definition": {"actions": {"baseBlob": {"delete": {"daysAfterLastAccessTimeGreaterThan": 1}}}}}]}`))
+	assert.ErrorContains(t, err, "rule x: delete has no (supported) condition")
+
+	// the warnings are in a fixed order
+	_, warnings, err = ParsePolicy([]byte(`{"rules": [{"name": "y", "definition": {"actions": {"baseBlob": {
+		"tierToHot": {"daysAfterModificationGreaterThan": 1},
+		"tierToCool": {"daysAfterModificationGreaterThan": 1, "daysAfterLastAccessTimeGreaterThan": 1},
+		"delete": {"daysAfterModificationGreaterThan": 2, "daysAfterLastAccessTimeGreaterThan": 2},
+		"enableAutoTierToHotFromCool": {"daysAfterModificationGreaterThan": 1}
+	}}}}]}`))
+	require.Nil(t, err)
+	assert.Equal(t, []string{
+		"rule y: delete daysAfterLastAccessTimeGreaterThan is ignored",
+		"rule y: tierToCool daysAfterLastAccessTimeGreaterThan is ignored",
+		"rule y: action enableAutoTierToHotFromCool is ignored",
+		"rule y: action tierToHot is ignored",
+	}, warnings)
+}
+
+func TestPolicy_actionSQL(t *testing.T) {
+	policy, _, err := ParsePolicy([]byte(testPolicy))
+	require.Nil(t, err)
+	runDate := time.Date(2024, 4, 18, 0, 0, 0, 0, time.UTC)
+	daysAgo := func(days int) int64 {
+		return runDate.AddDate(0, 0, -days).UnixMilli()
+	}
+	columns := []string{"Name", "BlobType", "AccessTier", "Last-Modified", "Creation-Time", "Snapshot", "IsCurrentVersion"}
+
+	db, err := sqlx.Connect("duckdb", "")
+	require.Nil(t, err)
+	defer db.Close()
+	var actions []string
+	// language=sql
+	err = db.Select(&actions, `SELECT coalesce(`+policy.actionSQL(columns, runDate)+`, '') FROM (VALUES
+		(1, 'logs/a.log', 'BlockBlob', 'Hot', ?, ?, NULL, NULL),
+		(2, 'logs/b.log', 'BlockBlob', 'Hot', ?, ?, NULL, NULL),
+		(3, 'logs/c.log', 'BlockBlob', 'Cool', ?, ?, NULL, NULL),
+		(4, 'logs/d.log', 'BlockBlob', 'Archive', ?, ?, NULL, NULL),
+		(5, 'logs/e.log', 'BlockBlob', 'Hot', ?, ?, NULL, NULL),
+		(6, 'logs/f.log', 'AppendBlob', 'Hot', ?, ?, NULL, NULL),
+		(7, 'other/g.log', 'BlockBlob', 'Hot', ?, ?, NULL, NULL),
+		(8, 'backups/it''s/h.bak', 'BlockBlob', 'Hot', ?, ?, NULL, NULL),
+		(9, 'backups/it''s/i.bak', 'BlockBlob', 'Cold', ?, ?, NULL, NULL),
+		(10, 'logs/e.log', 'BlockBlob', 'Hot', ?, ?, '2024-01-01T00:00:00.0000000Z', NULL),
+		(11, 'logs/e.log', 'BlockBlob', 'Hot', ?, ?, NULL, false),
+		(12, 'logs/e.log', 'BlockBlob', 'Hot', ?, ?, '', true)
+	) i(id, Name, BlobType, AccessTier, "Last-Modified", "Creation-Time", Snapshot, IsCurrentVersion) ORDER BY id`,
+		daysAgo(10), daysAgo(10),
+		daysAgo(40), daysAgo(40),
+		daysAgo(40), daysAgo(40),
+		daysAgo(100), daysAgo(100),
+		daysAgo(100), daysAgo(400),
+		daysAgo(400), daysAgo(400),
+		daysAgo(400), daysAgo(400),
+		daysAgo(8), daysAgo(8),
+		daysAgo(8), daysAgo(8),
+		daysAgo(100), daysAgo(400),
+		daysAgo(100), daysAgo(400),
+		daysAgo(100), daysAgo(400),
+	)
+	require.Nil(t, err)
+	expected := []string{
+		"",           // too young
+		"tierToCool", // 40 days
+		"",           // already cool
+		"",           // already archived
+		"delete",     // delete wins
+		"",           // not a block blob
+		"",           // not matching a prefix
+		"tierToCold", // prefix with quote
+		"",           // already cold
+		"",           // a snapshot
+		"",           // a previous version
+		"delete",     // the current version
+	}
+	assert.Equal(t, expected, actions)
+}
@@ -0,0 +1,77 @@
+package lifecycle
+
+import (
+	"cmp"
+	"slices"
+	"time"
+
+	"github.com/PDOK/azure-storage-usage-exporter/internal/agg"
+	"github.com/PDOK/azure-storage-usage-exporter/internal/du"
+	"github.com/PDOK/azure-storage-usage-exporter/internal/metrics"
+)
+
+// Effect is what a single action of the policy does with the blobs of an aggregation group
+type Effect struct {
+	AggregationGroup agg.AggregationGroup
+	Action           Action
+	// StorageUsage and Count are the bytes and number of blobs that are moved or deleted
+	StorageUsage du.StorageUsage
+	Count        int64
+	// CostBefore and CostAfter are the estimated monthly cost of those blobs before and after the action (zero without pricing)
+	CostBefore float64
+	CostAfter  float64
+}
+
+// Simulate evaluates the policy against an inventory run (as if the policy ran at the run date)
+// and aggregates the blobs that the policy acts on, per aggregation group and action.
+// Pricing is optional.
+func Simulate(reader du.ClassifiedRowsReader, runDate time.Time, policy Policy, aggregator *agg.Aggregator, pricing *metrics.PricingConfig) ([]Effect, error) {
+	classifiedRows, err := reader.ReadClassifiedRows(runDate, func(columns []string) string {
+		return policy.actionSQL(columns, runDate)
+	})
+	if err != nil {
+		return nil, err
+	}
+	rowsBefore := make(map[Action][]du.Row)
+	rowsAfter := make(map[Action][]du.Row)
+	for _, classifiedRow := range classifiedRows {
+		action := Action(classifiedRow.Class)
+		rowsBefore[action] = append(rowsBefore[action], classifiedRow.Row)
+		if action != ActionDelete {
+			rowAfter := classifiedRow.Row
+			rowAfter.AccessTier = action.TargetTier()
+			rowsAfter[action] = append(rowsAfter[action], rowAfter)
+		}
+	}
+
+	var effects []Effect
+	for _, action := range actionsBySeverity {
+		costsAfter := make(map[string]float64)
+		if pricing != nil {
+			for _, resultAfter := range aggregator.AggregateRows(rowsAfter[action]) {
+				costsAfter[resultAfter.AggregationGroup.Key()] = pricing.EstimateMonthlyCost(resultAfter)
+			}
+		}
+		for _, result := range aggregator.AggregateRows(rowsBefore[action]) {
+			effect := Effect{
+				AggregationGroup: result.AggregationGroup,
+				Action:           action,
+				StorageUsage:     result.StorageUsage,
+				Count:            result.Count,
+				CostAfter:        costsAfter[result.AggregationGroup.Key()],
+			}
+			if pricing != nil {
+				effect.CostBefore = pricing.EstimateMonthlyCost(result)
+			}
+			effects = append(effects, effect)
+		}
+	}
+	slices.SortStableFunc(effects, func(a, b Effect) int {
+		return cmp.Or(
+			cmp.Compare(slices.Index(actionsBySeverity, a.Action), slices.Index(actionsBySeverity, b.Action)),
+			cmp.Compare(b.StorageUsage, a.StorageUsage),
+			cmp.Compare(a.AggregationGroup.Key(), b.AggregationGroup.Key()),
+		)
+	})
+	return effects, nil
+}
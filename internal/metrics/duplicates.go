@@ -0,0 +1,114 @@
+package metrics
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/PDOK/azure-storage-usage-exporter/internal/agg"
+	"github.com/PDOK/azure-storage-usage-exporter/internal/du"
+	"github.com/creasty/defaults"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// DuplicatesFamilySuffix is the name of the reclaimable duplicate bytes metric of the default Family,
+// the metrics of other families are named <family>_duplicate_bytes
+const DuplicatesFamilySuffix = "duplicate_bytes"
+
+type DuplicatesConfig struct {
+	// Count is the number of largest duplicate sets to keep (and serve in the API)
+	Count int `yaml:"count" default:"100"`
+}
+
+type unmarshalledDuplicatesConfig DuplicatesConfig
+
+func (d *DuplicatesConfig) UnmarshalYAML(unmarshal func(any) error) error {
+	tmp := new(unmarshalledDuplicatesConfig)
+	if err := defaults.Set(tmp); err != nil {
+		return err
+	}
+	if err := unmarshal(tmp); err != nil {
+		return err
+	}
+	*d = DuplicatesConfig(*tmp)
+	return nil
+}
+
+type duplicates struct {
+	config DuplicatesConfig
+	reader du.DuplicatesReader
+	gauges []*prometheus.GaugeVec // per family
+
+	mu                          sync.RWMutex
+	runDate                     time.Time
+	duplicates                  du.Duplicates
+	aggregationResultsPerFamily [][]agg.AggregationResult
+}
+
+// WithDuplicates makes the Updater also analyze duplicate content after every run, when Config.Duplicates is given
+func (ms *Updater) WithDuplicates(reader du.DuplicatesReader) *Updater {
+	if ms.config.Duplicates == nil {
+		return ms
+	}
+	ms.duplicates = &duplicates{config: *ms.config.Duplicates, reader: reader}
+	for _, family := range ms.families {
+		name := DuplicatesFamilySuffix
+		if family.Name != DefaultFamily {
+			name = family.Name + "_" + DuplicatesFamilySuffix
+		}
+		ms.duplicates.gauges = append(ms.duplicates.gauges, promauto.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: ms.config.MetricNamespace,
+			Subsystem: ms.config.MetricSubsystem,
+			Name:      name,
+			Help:      "Bytes of duplicate content (same Content-MD5 and size) that could be reclaimed, of the " + family.Name + " metric",
+		}, family.Aggregator.GetLabelNames()))
+	}
+	return ms
+}
+
+// update analyzes the duplicates of the run. Failing is logged, it doesn't fail the whole update.
+func (d *duplicates) update(runDate time.Time, families []*familyState) {
+	duplicates, err := d.reader.ReadDuplicates(runDate, d.config.Count)
+	if err != nil {
+		log.Printf("analyzing duplicates failed: %s", err)
+		return
+	}
+	log.Printf("%d bytes of duplicate content could be reclaimed", duplicates.TotalReclaimableBytes)
+	aggregationResultsPerFamily := make([][]agg.AggregationResult, len(families))
+	for i, family := range families {
+		aggregationResultsPerFamily[i] = family.Aggregator.AggregateRows(duplicates.Rows)
+		d.gauges[i].Reset()
+		for j, aggregationResult := range aggregationResultsPerFamily[i] {
+			if j >= family.Limit {
+				break
+			}
+			d.gauges[i].With(aggregationGroupToLabels(aggregationResult.AggregationGroup)).Set(float64(aggregationResult.StorageUsage))
+		}
+	}
+	d.mu.Lock()
+	d.runDate = runDate
+	d.duplicates = duplicates
+	d.aggregationResultsPerFamily = aggregationResultsPerFamily
+	d.mu.Unlock()
+}
+
+// GetLastDuplicates returns the duplicates analysis of the last processed run,
+// with the reclaimable bytes aggregated by the given family (metric).
+// ok is false when duplicates are not configured or the family doesn't exist.
+func (ms *Updater) GetLastDuplicates(familyName string) (runDate time.Time, duplicates du.Duplicates, aggregationResults []agg.AggregationResult, ok bool) {
+	if ms.duplicates == nil {
+		return time.Time{}, du.Duplicates{}, nil, false
+	}
+	ms.duplicates.mu.RLock()
+	defer ms.duplicates.mu.RUnlock()
+	for i, family := range ms.families {
+		if family.Name == familyName {
+			if ms.duplicates.aggregationResultsPerFamily != nil {
+				aggregationResults = ms.duplicates.aggregationResultsPerFamily[i]
+			}
+			return ms.duplicates.runDate, ms.duplicates.duplicates, aggregationResults, true
+		}
+	}
+	return time.Time{}, du.Duplicates{}, nil, false
+}
@@ -2,28 +2,122 @@ package metrics
 
 import (
 	"log"
+	"maps"
 	"strconv"
+	"sync"
 	"time"
 
 	"github.com/creasty/defaults"
 
 	"github.com/PDOK/azure-storage-usage-exporter/internal/agg"
+	"github.com/PDOK/azure-storage-usage-exporter/internal/du"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
 )
 
 type Updater struct {
-	config            Config
-	aggregator        *agg.Aggregator
-	storageUsageGauge *prometheus.GaugeVec
-	lastRunDateMetric prometheus.Gauge
-	lastRunDate       time.Time
+	config             Config
+	families           []*familyState
+	multiAggregator    *agg.MultiAggregator
+	lastRunDateMetric  prometheus.Gauge
+	ruleBytesGauge     *prometheus.GaugeVec
+	excludedBytesGauge *prometheus.GaugeVec
+	ruleDuRowsGauge    *prometheus.GaugeVec
+	quotaGauges        *quotaGauges         // nil when there are no quotas
+	topDirBytesGauge   *prometheus.GaugeVec // nil when top dirs are not configured
+	topBlobs           *topBlobs            // nil when top blobs are not configured
+	duplicates         *duplicates          // nil when duplicates are not configured
+
+	mu            sync.RWMutex // guards the fields below (and in familyState), which are also read by the API
+	lastRunDate   time.Time
+	updating      bool
+	failedRunDate time.Time
+	quotaUsages   []QuotaUsage
+}
+
+// Family is a storage usage metric (family) with its own aggregator, so its own labels and rules.
+// All families are aggregated from the same du rows.
+type Family struct {
+	// Name of the metric, without namespace and subsystem
+	Name string
+	// Limit is the max number of metrics, 0 means Config.Limit
+	Limit      int
+	Aggregator *agg.Aggregator
+}
+
+type familyState struct {
+	Family
+	storageUsageGauge      *prometheus.GaugeVec
+	costGauge              *prometheus.GaugeVec    // nil when there is no pricing config
+	sizeHistogram          *sizeHistogramCollector // nil when there is no size histogram config
+	lastAggregationResults []agg.AggregationResult
+}
+
+type RunStatus string
+
+const (
+	RunStatusProcessed  RunStatus = "processed"
+	RunStatusProcessing RunStatus = "processing"
+	RunStatusFailed     RunStatus = "failed"
+	RunStatusPending    RunStatus = "pending"
+	RunStatusSkipped    RunStatus = "skipped"
+)
+
+// Run is a du.Run including whether (and how) it was processed by the Updater
+type Run struct {
+	du.Run
+	Status RunStatus
 }
 
+const (
+	// DefaultFamily is the name of the metric (family) configured by the top level labels and rules
+	DefaultFamily = "usage"
+
+	familyLabel = "metric"
+	ruleLabel   = "rule"
+)
+
+// ReservedNames are metric names that can't be used for a Family
+var ReservedNames = []string{"last_run_date", "rule_bytes", "rule_du_rows", "excluded_bytes", CostFamilySuffix,
+	"quota_bytes", "quota_utilization_ratio", "quota_remaining_bytes", "top_dir_bytes", "top_blob_bytes", SizeHistogramFamilySuffix, DuplicatesFamilySuffix}
+
 type Config struct {
 	MetricNamespace string `yaml:"metricNamespace" default:"azure"`
 	MetricSubsystem string `yaml:"metricSubsystem" default:"storage"`
 	Limit           int    `yaml:"limit" default:"1000"`
+	// Pricing is optional, when given the estimated monthly cost is exported as well
+	Pricing *PricingConfig `yaml:"pricing,omitempty"`
+	// Quotas are optional, for each quota its limit, utilization and remaining bytes are exported
+	Quotas []QuotaConfig `yaml:"quotas,omitempty"`
+	// TopDirs is optional, when given the largest dirs are exported (independent of labels and rules)
+	TopDirs *TopDirsConfig `yaml:"topDirs,omitempty"`
+	// TopBlobs is optional, when given the largest individual blobs are queried after every run
+	TopBlobs *TopBlobsConfig `yaml:"topBlobs,omitempty"`
+	// SizeHistogram is optional, when given the blob sizes per aggregation group are exported as histogram
+	SizeHistogram *SizeHistogramConfig `yaml:"sizeHistogram,omitempty"`
+	// Duplicates is optional, when given duplicate content (by Content-MD5) is analyzed after every run
+	Duplicates *DuplicatesConfig `yaml:"duplicates,omitempty"`
+}
+
+type TopDirsConfig struct {
+	// Count is the number of largest dirs to export
+	Count int `yaml:"count" default:"10"`
+	// Depth truncates the dirs to this number of segments (including the container), 0 means as deep as the du rows go
+	Depth int `yaml:"depth"`
+}
+
+type unmarshalledTopDirsConfig TopDirsConfig
+
+func (t *TopDirsConfig) UnmarshalYAML(unmarshal func(any) error) error {
+	tmp := new(unmarshalledTopDirsConfig)
+	if err := defaults.Set(tmp); err != nil {
+		return err
+	}
+	if err := unmarshal(tmp); err != nil {
+		return err
+	}
+	*t = TopDirsConfig(*tmp)
+	return nil
 }
 
 type unmarshalledConfig Config
@@ -40,15 +134,36 @@ func (c *Config) UnmarshalYAML(unmarshal func(any) error) error {
 	return nil
 }
 
-func NewUpdater(aggregator *agg.Aggregator, config Config) *Updater {
-	// promauto automatically registers with prometheus.DefaultRegisterer
-	storageUsageGauge := promauto.NewGaugeVec(prometheus.GaugeOpts{
-		Namespace: config.MetricNamespace,
-		Subsystem: config.MetricSubsystem,
-		Name:      "usage",
-	}, aggregator.GetLabelNames())
+func NewUpdater(families []Family, config Config) *Updater {
+	familyStates := make([]*familyState, len(families))
+	aggregators := make([]*agg.Aggregator, len(families))
+	for i, family := range families {
+		if family.Limit == 0 {
+			family.Limit = config.Limit
+		}
+		// promauto automatically registers with prometheus.DefaultRegisterer
+		storageUsageGauge := promauto.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: config.MetricNamespace,
+			Subsystem: config.MetricSubsystem,
+			Name:      family.Name,
+		}, family.Aggregator.GetLabelNames())
+		familyStates[i] = &familyState{Family: family, storageUsageGauge: storageUsageGauge}
+		if config.Pricing != nil {
+			familyStates[i].costGauge = promauto.NewGaugeVec(prometheus.GaugeOpts{
+				Namespace: config.MetricNamespace,
+				Subsystem: config.MetricSubsystem,
+				Name:      costFamilyName(family.Name),
+				Help:      "Estimated monthly cost of the " + family.Name + " metric, based on the configured pricing",
+			}, family.Aggregator.GetLabelNames())
+		}
+		if config.SizeHistogram != nil {
+			familyStates[i].sizeHistogram = newSizeHistogramCollector(config, family.Name, family.Aggregator.GetLabelNames())
+			prometheus.MustRegister(familyStates[i].sizeHistogram)
+		}
+		aggregators[i] = family.Aggregator
+	}
 	lastRunDateMetricLabels := prometheus.Labels{}
-	storageAccountName := aggregator.GetStorageAccountName()
+	storageAccountName := families[0].Aggregator.GetStorageAccountName()
 	if storageAccountName != "" {
 		lastRunDateMetricLabels[agg.StorageAccount] = storageAccountName
 	}
@@ -58,46 +173,219 @@ func NewUpdater(aggregator *agg.Aggregator, config Config) *Updater {
 		Name:        "last_run_date",
 		ConstLabels: lastRunDateMetricLabels,
 	})
+	ruleBytesGauge := promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace:   config.MetricNamespace,
+		Subsystem:   config.MetricSubsystem,
+		Name:        "rule_bytes",
+		Help:        "Bytes matched by each rule (by index) of each metric, rule=\"default\" is what matched no rule",
+		ConstLabels: lastRunDateMetricLabels,
+	}, []string{familyLabel, ruleLabel})
+	ruleDuRowsGauge := promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace:   config.MetricNamespace,
+		Subsystem:   config.MetricSubsystem,
+		Name:        "rule_du_rows",
+		Help:        "Count of du rows matched by each rule (by index) of each metric, rule=\"default\" is what matched no rule",
+		ConstLabels: lastRunDateMetricLabels,
+	}, []string{familyLabel, ruleLabel})
+	excludedBytesGauge := promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace:   config.MetricNamespace,
+		Subsystem:   config.MetricSubsystem,
+		Name:        "excluded_bytes",
+		Help:        "Bytes dropped by rules of each metric, which are not in the metric itself",
+		ConstLabels: lastRunDateMetricLabels,
+	}, []string{familyLabel, agg.Deleted})
+	var quotaGauges *quotaGauges
+	if len(config.Quotas) > 0 {
+		quotaGauges = newQuotaGauges(config, lastRunDateMetricLabels)
+	}
+	multiAggregator := agg.NewMultiAggregator(aggregators...)
+	var topDirBytesGauge *prometheus.GaugeVec
+	if config.TopDirs != nil {
+		multiAggregator.WithTopDirs(config.TopDirs.Count, config.TopDirs.Depth)
+		topDirBytesGauge = promauto.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace:   config.MetricNamespace,
+			Subsystem:   config.MetricSubsystem,
+			Name:        "top_dir_bytes",
+			Help:        "Bytes of the largest dirs (including deleted blobs), independent of labels and rules",
+			ConstLabels: lastRunDateMetricLabels,
+		}, []string{"dir"})
+	}
 	return &Updater{
-		config:            config,
-		aggregator:        aggregator,
-		storageUsageGauge: storageUsageGauge,
-		lastRunDateMetric: lastRunDateMetric,
+		config:             config,
+		families:           familyStates,
+		multiAggregator:    multiAggregator,
+		lastRunDateMetric:  lastRunDateMetric,
+		ruleBytesGauge:     ruleBytesGauge,
+		ruleDuRowsGauge:    ruleDuRowsGauge,
+		excludedBytesGauge: excludedBytesGauge,
+		quotaGauges:        quotaGauges,
+		topDirBytesGauge:   topDirBytesGauge,
 	}
 }
 
 func (ms *Updater) UpdatePromMetrics() error {
-	log.Printf("start updating metrics. previous run was %s", ms.lastRunDate)
-	aggregationResults, lastRunDate, err := ms.aggregator.Aggregate(ms.lastRunDate)
+	ms.mu.Lock()
+	previousRunDate := ms.lastRunDate
+	ms.updating = true
+	ms.mu.Unlock()
+	defer func() {
+		ms.mu.Lock()
+		ms.updating = false
+		ms.mu.Unlock()
+	}()
+
+	log.Printf("start updating metrics. previous run was %s", previousRunDate)
+	aggregationResultsPerFamily, lastRunDate, err := ms.multiAggregator.Aggregate(previousRunDate)
 	if err != nil {
-		if !lastRunDate.IsZero() && lastRunDate.Equal(ms.lastRunDate) {
+		if !lastRunDate.IsZero() && lastRunDate.Equal(previousRunDate) {
 			log.Print("no newer blob inventory run found")
 			return nil
 		}
+		ms.mu.Lock()
+		ms.failedRunDate = lastRunDate
+		ms.mu.Unlock()
 		return err
 	}
 
 	log.Print("start setting metrics")
-	ms.lastRunDate = lastRunDate
 	ms.lastRunDateMetric.Set(float64(lastRunDate.UnixNano()) / 1e9)
-	ms.storageUsageGauge.Reset()
+	ms.ruleBytesGauge.Reset()
+	ms.ruleDuRowsGauge.Reset()
+	for i, family := range ms.families {
+		family.setMetrics(aggregationResultsPerFamily[i], ms.config.Pricing)
+		coverage := family.Aggregator.GetLastCoverage()
+		for _, ruleCoverage := range coverage.Rules {
+			ms.ruleBytesGauge.WithLabelValues(family.Name, ruleCoverage.Rule).Set(float64(ruleCoverage.Bytes))
+			ms.ruleDuRowsGauge.WithLabelValues(family.Name, ruleCoverage.Rule).Set(float64(ruleCoverage.DuRows))
+		}
+		ms.excludedBytesGauge.WithLabelValues(family.Name, "false").Set(float64(coverage.Excluded.Bytes))
+		ms.excludedBytesGauge.WithLabelValues(family.Name, "true").Set(float64(coverage.Excluded.DeletedBytes))
+	}
+
+	if ms.topDirBytesGauge != nil {
+		ms.topDirBytesGauge.Reset()
+		for _, topDir := range ms.multiAggregator.GetLastTopDirs() {
+			ms.topDirBytesGauge.WithLabelValues(topDir.Dir).Set(float64(topDir.Bytes))
+		}
+	}
+	aggregationResultsByFamilyName := make(map[string][]agg.AggregationResult, len(ms.families))
+	for i, family := range ms.families {
+		aggregationResultsByFamilyName[family.Name] = aggregationResultsPerFamily[i]
+	}
+	quotaUsages := computeQuotaUsages(ms.config.Quotas, aggregationResultsByFamilyName)
+	if ms.quotaGauges != nil {
+		ms.quotaGauges.set(quotaUsages)
+	}
+	if ms.topBlobs != nil {
+		ms.topBlobs.update(lastRunDate)
+	}
+	if ms.duplicates != nil {
+		ms.duplicates.update(lastRunDate, ms.families)
+	}
+
+	ms.mu.Lock()
+	ms.lastRunDate = lastRunDate
+	for i, family := range ms.families {
+		family.lastAggregationResults = aggregationResultsPerFamily[i]
+	}
+	ms.quotaUsages = quotaUsages
+	ms.mu.Unlock()
+	log.Printf("done updating metrics for run %s", lastRunDate)
 
-	if len(aggregationResults) > ms.config.Limit {
-		log.Printf("(metrics count will be limited to %d (of %d)", ms.config.Limit, len(aggregationResults))
+	return nil
+}
+
+func (f *familyState) setMetrics(aggregationResults []agg.AggregationResult, pricing *PricingConfig) {
+	f.storageUsageGauge.Reset()
+	if f.costGauge != nil {
+		f.costGauge.Reset()
+	}
+	if len(aggregationResults) > f.Limit {
+		log.Printf("(%s metrics count will be limited to %d (of %d)", f.Name, f.Limit, len(aggregationResults))
+	}
+	if f.sizeHistogram != nil {
+		f.sizeHistogram.set(aggregationResults[:min(len(aggregationResults), f.Limit)])
 	}
 	for i, aggregationResult := range aggregationResults {
-		if i >= ms.config.Limit {
+		if i >= f.Limit {
 			break
 		}
-		ms.storageUsageGauge.With(aggregationGroupToLabels(aggregationResult.AggregationGroup)).Set(float64(aggregationResult.StorageUsage))
+		labels := aggregationGroupToLabels(aggregationResult.AggregationGroup)
+		f.storageUsageGauge.With(labels).Set(float64(aggregationResult.StorageUsage))
+		if f.costGauge != nil {
+			f.costGauge.With(labels).Set(pricing.EstimateMonthlyCost(aggregationResult))
+		}
 	}
-	log.Printf("done updating metrics for run %s", ms.lastRunDate)
+}
 
-	return nil
+// costFamilyName is estimated_monthly_cost for the default family, and <name>_estimated_monthly_cost for others
+func costFamilyName(familyName string) string {
+	if familyName == DefaultFamily {
+		return CostFamilySuffix
+	}
+	return familyName + "_" + CostFamilySuffix
+}
+
+// GetFamily returns the family (metric) with the given name
+func (ms *Updater) GetFamily(name string) (Family, bool) {
+	for _, family := range ms.families {
+		if family.Name == name {
+			return family.Family, true
+		}
+	}
+	return Family{}, false
+}
+
+// GetLastAggregationResults returns the results of the given family (metric) of the last processed run
+// (all of them, not limited by Config.Limit)
+func (ms *Updater) GetLastAggregationResults(familyName string) (runDate time.Time, aggregationResults []agg.AggregationResult, ok bool) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	for _, family := range ms.families {
+		if family.Name == familyName {
+			return ms.lastRunDate, family.lastAggregationResults, true
+		}
+	}
+	return ms.lastRunDate, nil, false
+}
+
+// GetLastQuotaUsages returns the usage of each quota in the last processed run
+func (ms *Updater) GetLastQuotaUsages() []QuotaUsage {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	return ms.quotaUsages
+}
+
+// GetRuns lists the available runs, newest first, with their processing status
+func (ms *Updater) GetRuns() ([]Run, error) {
+	duRuns, err := ms.multiAggregator.ListRuns()
+	if err != nil {
+		return nil, err
+	}
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	runs := make([]Run, len(duRuns))
+	for i, duRun := range duRuns {
+		status := RunStatusSkipped // only the newest run is processed, older ones are skipped
+		switch {
+		case duRun.Date.Equal(ms.lastRunDate):
+			status = RunStatusProcessed
+		case duRun.Date.Before(ms.lastRunDate):
+			// keep skipped
+		case i == 0 && ms.updating:
+			status = RunStatusProcessing
+		case duRun.Date.Equal(ms.failedRunDate):
+			status = RunStatusFailed
+		case i == 0:
+			status = RunStatusPending
+		}
+		runs[i] = Run{Run: duRun, Status: status}
+	}
+	return runs, nil
 }
 
 func aggregationGroupToLabels(aggregationGroup agg.AggregationGroup) prometheus.Labels {
-	labels := aggregationGroup.Labels
+	labels := maps.Clone(aggregationGroup.Labels)
 	labels[agg.Deleted] = strconv.FormatBool(aggregationGroup.Deleted)
 	return labels
 }
@@ -0,0 +1,78 @@
+package metrics
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/PDOK/azure-storage-usage-exporter/internal/agg"
+	"github.com/creasty/defaults"
+	"golang.org/x/exp/maps"
+)
+
+const (
+	// CostFamilySuffix is the name of the estimated cost metric of the default Family,
+	// the cost metrics of other families are named <family>_estimated_monthly_cost
+	CostFamilySuffix = "estimated_monthly_cost"
+
+	bytesPerGB = 1 << 30 // Azure bills per binary GB (GiB)
+	objectsPer = 10_000
+)
+
+// PricingConfig is used to estimate the monthly cost of the storage usage.
+// Prices are in whatever currency is used, as long as it's the same everywhere.
+type PricingConfig struct {
+	// Redundancy of the storage account (e.g. LRS, ZRS, GRS), selects the prices from PerGBMonth
+	Redundancy string `yaml:"redundancy" default:"LRS"`
+	// DefaultAccessTier is used for blobs of which the access tier is unknown (e.g. not in the inventory)
+	DefaultAccessTier string `yaml:"defaultAccessTier" default:"Hot"`
+	// PerGBMonth is the price per GB per month, per redundancy and access tier (as in the inventory: Hot, Cool, Cold, Archive)
+	PerGBMonth map[string]map[string]float64 `yaml:"perGBMonth"`
+	// Per10kObjectsMonth is the (optional) price per 10,000 blobs per month, per access tier
+	Per10kObjectsMonth map[string]float64 `yaml:"per10kObjectsMonth,omitempty"`
+}
+
+type unmarshalledPricingConfig PricingConfig
+
+func (p *PricingConfig) UnmarshalYAML(unmarshal func(any) error) error {
+	tmp := new(unmarshalledPricingConfig)
+	if err := defaults.Set(tmp); err != nil {
+		return err
+	}
+	if err := unmarshal(tmp); err != nil {
+		return err
+	}
+	*p = PricingConfig(*tmp)
+	return nil
+}
+
+// Validate checks whether there are prices for the configured redundancy and default access tier
+func (p *PricingConfig) Validate() []agg.Issue {
+	if p == nil {
+		return nil
+	}
+	pricesPerTier, ok := p.PerGBMonth[p.Redundancy]
+	if !ok {
+		redundancies := maps.Keys(p.PerGBMonth)
+		slices.Sort(redundancies)
+		return []agg.Issue{{Severity: agg.SeverityError, Message: fmt.Sprintf("pricing: no prices for redundancy %q (only for %v)", p.Redundancy, redundancies)}}
+	}
+	if _, ok := pricesPerTier[p.DefaultAccessTier]; !ok {
+		return []agg.Issue{{Severity: agg.SeverityError, Message: fmt.Sprintf("pricing: no price for default access tier %q (redundancy %s)", p.DefaultAccessTier, p.Redundancy)}}
+	}
+	return nil
+}
+
+// EstimateMonthlyCost estimates what the storage usage of an aggregation result costs per month.
+// Access tiers without a price are priced as the default access tier.
+func (p *PricingConfig) EstimateMonthlyCost(aggregationResult agg.AggregationResult) float64 {
+	pricesPerTier := p.PerGBMonth[p.Redundancy]
+	var cost float64
+	for accessTier, tierUsage := range aggregationResult.UsageByAccessTier {
+		if _, ok := pricesPerTier[accessTier]; !ok {
+			accessTier = p.DefaultAccessTier
+		}
+		cost += float64(tierUsage.StorageUsage) / bytesPerGB * pricesPerTier[accessTier]
+		cost += float64(tierUsage.Count) / objectsPer * p.Per10kObjectsMonth[accessTier]
+	}
+	return cost
+}
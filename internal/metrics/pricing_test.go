@@ -0,0 +1,48 @@
+package metrics
+
+import (
+	"testing"
+
+	"github.com/PDOK/azure-storage-usage-exporter/internal/agg"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPricingConfig_EstimateMonthlyCost(t *testing.T) {
+	pricing := &PricingConfig{
+		Redundancy:        "LRS",
+		DefaultAccessTier: "Hot",
+		PerGBMonth: map[string]map[string]float64{
+			"LRS": {"Hot": 0.02, "Cool": 0.01, "Archive": 0.002},
+			"GRS": {"Hot": 0.04},
+		},
+		Per10kObjectsMonth: map[string]float64{"Archive": 0.5},
+	}
+	tests := []struct {
+		name              string
+		usageByAccessTier map[string]agg.TierUsage
+		want              float64
+	}{
+		{name: "empty", usageByAccessTier: nil, want: 0},
+		{name: "hot", usageByAccessTier: map[string]agg.TierUsage{"Hot": {StorageUsage: 10 * bytesPerGB, Count: 1}}, want: 0.2},
+		{name: "unknown tier priced as default", usageByAccessTier: map[string]agg.TierUsage{"": {StorageUsage: 10 * bytesPerGB, Count: 1}}, want: 0.2},
+		{name: "mixed with object fee", usageByAccessTier: map[string]agg.TierUsage{
+			"Cool":    {StorageUsage: 100 * bytesPerGB, Count: 5},
+			"Archive": {StorageUsage: 1000 * bytesPerGB, Count: 20_000},
+		}, want: 1 + 2 + 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := pricing.EstimateMonthlyCost(agg.AggregationResult{UsageByAccessTier: tt.usageByAccessTier})
+			assert.InDelta(t, tt.want, got, 1e-9)
+		})
+	}
+}
+
+func TestPricingConfig_Validate(t *testing.T) {
+	var noPricing *PricingConfig
+	assert.Empty(t, noPricing.Validate())
+	pricing := &PricingConfig{Redundancy: "ZRS", DefaultAccessTier: "Hot", PerGBMonth: map[string]map[string]float64{"LRS": {"Hot": 0.02}}}
+	assert.Len(t, pricing.Validate(), 1)
+	pricing.Redundancy = "LRS"
+	assert.Empty(t, pricing.Validate())
+}
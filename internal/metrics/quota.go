@@ -0,0 +1,177 @@
+package metrics
+
+import (
+	"fmt"
+	"math"
+	"slices"
+
+	"github.com/PDOK/azure-storage-usage-exporter/internal/agg"
+	"github.com/PDOK/azure-storage-usage-exporter/internal/du"
+	"github.com/creasty/defaults"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"golang.org/x/exp/maps"
+)
+
+// QuotaConfig is a storage limit for all aggregation groups (of a metric) that have the given labels
+type QuotaConfig struct {
+	// Metric is the name of the Family to which the labels belong
+	Metric string `yaml:"metric" default:"usage"`
+	// Labels select the aggregation groups that count towards the quota, e.g. tenant: foo
+	Labels agg.Labels  `yaml:"labels"`
+	Limit  du.ByteSize `yaml:"limit"`
+	// IncludeDeleted tells whether (soft) deleted data counts towards the quota
+	IncludeDeleted bool `yaml:"includeDeleted" default:"true"`
+}
+
+type unmarshalledQuotaConfig QuotaConfig
+
+func (q *QuotaConfig) UnmarshalYAML(unmarshal func(any) error) error {
+	tmp := new(unmarshalledQuotaConfig)
+	if err := defaults.Set(tmp); err != nil {
+		return err
+	}
+	if err := unmarshal(tmp); err != nil {
+		return err
+	}
+	*q = QuotaConfig(*tmp)
+	return nil
+}
+
+// QuotaUsage is how much of a quota is used
+type QuotaUsage struct {
+	Quota        QuotaConfig
+	StorageUsage du.StorageUsage
+}
+
+// Remaining returns the bytes that are left, negative when the quota is exceeded
+func (q QuotaUsage) Remaining() du.StorageUsage {
+	return du.StorageUsage(q.Quota.Limit) - q.StorageUsage
+}
+
+// Utilization returns the used fraction of the quota, above 1 when the quota is exceeded
+func (q QuotaUsage) Utilization() float64 {
+	if q.Quota.Limit == 0 {
+		return math.Inf(1)
+	}
+	return float64(q.StorageUsage) / float64(q.Quota.Limit)
+}
+
+// ValidateQuotas checks whether the quotas refer to existing metrics and labels, and don't overlap
+func ValidateQuotas(quotas []QuotaConfig, labelNamesPerFamily map[string][]string) []agg.Issue {
+	var issues []agg.Issue
+	for i, quota := range quotas {
+		labelNames, ok := labelNamesPerFamily[quota.Metric]
+		if !ok {
+			issues = append(issues, agg.Issue{Severity: agg.SeverityError, Message: fmt.Sprintf("quota %d: unknown metric %q", i, quota.Metric)})
+			continue
+		}
+		if len(quota.Labels) == 0 {
+			issues = append(issues, agg.Issue{Severity: agg.SeverityError, Message: fmt.Sprintf("quota %d: no labels", i)})
+		}
+		for _, labelName := range sortedKeys(quota.Labels) {
+			if labelName == familyLabel || labelName == agg.StorageAccount {
+				issues = append(issues, agg.Issue{Severity: agg.SeverityError, Message: fmt.Sprintf("quota %d: label %q can't be used in quotas", i, labelName)})
+			} else if !slices.Contains(labelNames, labelName) {
+				issues = append(issues, agg.Issue{Severity: agg.SeverityError, Message: fmt.Sprintf("quota %d: label %q is not a label of metric %s", i, labelName, quota.Metric)})
+			}
+		}
+		for j, other := range quotas[:i] {
+			if other.Metric == quota.Metric && maps.Equal(other.Labels, quota.Labels) {
+				issues = append(issues, agg.Issue{Severity: agg.SeverityError, Message: fmt.Sprintf("quota %d has the same labels as quota %d", i, j)})
+			}
+		}
+	}
+	return issues
+}
+
+// computeQuotaUsages sums the storage usage of the aggregation groups that match each quota
+func computeQuotaUsages(quotas []QuotaConfig, aggregationResultsPerFamily map[string][]agg.AggregationResult) []QuotaUsage {
+	quotaUsages := make([]QuotaUsage, len(quotas))
+	for i, quota := range quotas {
+		quotaUsages[i].Quota = quota
+		for _, aggregationResult := range aggregationResultsPerFamily[quota.Metric] {
+			if quota.matches(aggregationResult.AggregationGroup) {
+				quotaUsages[i].StorageUsage += aggregationResult.StorageUsage
+			}
+		}
+	}
+	return quotaUsages
+}
+
+func (q QuotaConfig) matches(aggregationGroup agg.AggregationGroup) bool {
+	if aggregationGroup.Deleted && !q.IncludeDeleted {
+		return false
+	}
+	for labelName, labelValue := range q.Labels {
+		if aggregationGroup.Labels[labelName] != labelValue {
+			return false
+		}
+	}
+	return true
+}
+
+type quotaGauges struct {
+	labelNames       []string
+	bytesGauge       *prometheus.GaugeVec
+	utilizationGauge *prometheus.GaugeVec
+	remainingGauge   *prometheus.GaugeVec
+}
+
+func newQuotaGauges(config Config, constLabels prometheus.Labels) *quotaGauges {
+	labelNames := quotaLabelNames(config.Quotas)
+	newGaugeVec := func(name, help string) *prometheus.GaugeVec {
+		return promauto.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace:   config.MetricNamespace,
+			Subsystem:   config.MetricSubsystem,
+			Name:        name,
+			Help:        help,
+			ConstLabels: constLabels,
+		}, labelNames)
+	}
+	return &quotaGauges{
+		labelNames:       labelNames,
+		bytesGauge:       newGaugeVec("quota_bytes", "Configured quota in bytes"),
+		utilizationGauge: newGaugeVec("quota_utilization_ratio", "Used fraction of the quota, above 1 when the quota is exceeded"),
+		remainingGauge:   newGaugeVec("quota_remaining_bytes", "Bytes left of the quota, negative when the quota is exceeded"),
+	}
+}
+
+func (g *quotaGauges) set(quotaUsages []QuotaUsage) {
+	for _, quotaUsage := range quotaUsages {
+		labels := quotaToLabels(quotaUsage.Quota, g.labelNames)
+		g.bytesGauge.With(labels).Set(float64(quotaUsage.Quota.Limit))
+		g.utilizationGauge.With(labels).Set(quotaUsage.Utilization())
+		g.remainingGauge.With(labels).Set(float64(quotaUsage.Remaining()))
+	}
+}
+
+// quotaLabelNames returns the union of the labels of all quotas (plus the metric label),
+// quotas that don't have one of those labels get an empty value (which Prometheus treats as absent)
+func quotaLabelNames(quotas []QuotaConfig) []string {
+	labelNames := []string{familyLabel}
+	for _, quota := range quotas {
+		for labelName := range quota.Labels {
+			if !slices.Contains(labelNames, labelName) {
+				labelNames = append(labelNames, labelName)
+			}
+		}
+	}
+	slices.Sort(labelNames[1:])
+	return labelNames
+}
+
+func quotaToLabels(quota QuotaConfig, labelNames []string) prometheus.Labels {
+	labels := prometheus.Labels{}
+	for _, labelName := range labelNames {
+		labels[labelName] = quota.Labels[labelName]
+	}
+	labels[familyLabel] = quota.Metric
+	return labels
+}
+
+func sortedKeys(labels agg.Labels) []string {
+	keys := maps.Keys(labels)
+	slices.Sort(keys)
+	return keys
+}
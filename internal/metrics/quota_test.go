@@ -0,0 +1,47 @@
+package metrics
+
+import (
+	"testing"
+
+	"github.com/PDOK/azure-storage-usage-exporter/internal/agg"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v2"
+)
+
+func TestQuotaConfig_UnmarshalYAML(t *testing.T) {
+	var quotas []QuotaConfig
+	require.NoError(t, yaml.Unmarshal([]byte(`
+- labels: {tenant: foo}
+  limit: 1024
+- metric: usage_by_cost_center
+  labels: {cost_center: finance}
+  limit: 1GiB
+  includeDeleted: false
+`), &quotas))
+	assert.Equal(t, []QuotaConfig{
+		{Metric: DefaultFamily, Labels: agg.Labels{"tenant": "foo"}, Limit: 1024, IncludeDeleted: true},
+		{Metric: "usage_by_cost_center", Labels: agg.Labels{"cost_center": "finance"}, Limit: 1 << 30, IncludeDeleted: false},
+	}, quotas)
+}
+
+func Test_computeQuotaUsages(t *testing.T) {
+	quotas := []QuotaConfig{
+		{Metric: DefaultFamily, Labels: agg.Labels{"tenant": "foo"}, Limit: 1000, IncludeDeleted: true},
+		{Metric: DefaultFamily, Labels: agg.Labels{"tenant": "foo", "type": "a"}, Limit: 100, IncludeDeleted: false},
+	}
+	aggregationResults := map[string][]agg.AggregationResult{DefaultFamily: {
+		{AggregationGroup: agg.AggregationGroup{Labels: agg.Labels{"tenant": "foo", "type": "a"}}, StorageUsage: 300},
+		{AggregationGroup: agg.AggregationGroup{Labels: agg.Labels{"tenant": "foo", "type": "a"}, Deleted: true}, StorageUsage: 20},
+		{AggregationGroup: agg.AggregationGroup{Labels: agg.Labels{"tenant": "foo", "type": "b"}}, StorageUsage: 200},
+		{AggregationGroup: agg.AggregationGroup{Labels: agg.Labels{"tenant": "bar", "type": "a"}}, StorageUsage: 5000},
+	}}
+	quotaUsages := computeQuotaUsages(quotas, aggregationResults)
+	require.Len(t, quotaUsages, 2)
+	assert.EqualValues(t, 520, quotaUsages[0].StorageUsage)
+	assert.EqualValues(t, 480, quotaUsages[0].Remaining())
+	assert.InDelta(t, 0.52, quotaUsages[0].Utilization(), 1e-9)
+	assert.EqualValues(t, 300, quotaUsages[1].StorageUsage)
+	assert.EqualValues(t, -200, quotaUsages[1].Remaining())
+	assert.InDelta(t, 3.0, quotaUsages[1].Utilization(), 1e-9)
+}
@@ -0,0 +1,113 @@
+package metrics
+
+import (
+	"fmt"
+	"slices"
+	"sync"
+
+	"github.com/PDOK/azure-storage-usage-exporter/internal/agg"
+	"github.com/PDOK/azure-storage-usage-exporter/internal/du"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// SizeHistogramFamilySuffix is the name of the blob size histogram of the default Family,
+// the histograms of other families are named <family>_blob_size_bytes
+const SizeHistogramFamilySuffix = "blob_size_bytes"
+
+// DefaultSizeBuckets are 4KiB, 64KiB, 1MiB and 100MiB
+var DefaultSizeBuckets = []du.ByteSize{4 << 10, 64 << 10, 1 << 20, 100 << 20}
+
+type SizeHistogramConfig struct {
+	// Buckets are the (inclusive) upper bounds of the size buckets, in ascending order
+	Buckets []du.ByteSize `yaml:"buckets"`
+}
+
+// GetBuckets returns the configured or else the default buckets, in bytes
+func (s *SizeHistogramConfig) GetBuckets() []int64 {
+	buckets := s.Buckets
+	if len(buckets) == 0 {
+		buckets = DefaultSizeBuckets
+	}
+	bounds := make([]int64, len(buckets))
+	for i, bucket := range buckets {
+		bounds[i] = int64(bucket)
+	}
+	return bounds
+}
+
+// Validate checks whether the buckets are ascending
+func (s *SizeHistogramConfig) Validate() []agg.Issue {
+	if s == nil {
+		return nil
+	}
+	bounds := s.GetBuckets()
+	if !slices.IsSorted(bounds) || len(slices.Compact(slices.Clone(bounds))) != len(bounds) {
+		return []agg.Issue{{Severity: agg.SeverityError, Message: fmt.Sprintf("sizeHistogram: buckets must be ascending: %v", bounds)}}
+	}
+	return nil
+}
+
+// sizeHistogramCollector exports the size buckets of the aggregation results as Prometheus histograms,
+// with the sizes as sum and the blob counts as count
+type sizeHistogramCollector struct {
+	desc       *prometheus.Desc
+	labelNames []string
+	bounds     []float64
+
+	mu                 sync.RWMutex
+	aggregationResults []agg.AggregationResult
+}
+
+func newSizeHistogramCollector(config Config, familyName string, labelNames []string) *sizeHistogramCollector {
+	name := SizeHistogramFamilySuffix
+	if familyName != DefaultFamily {
+		name = familyName + "_" + SizeHistogramFamilySuffix
+	}
+	bounds := make([]float64, 0, len(config.SizeHistogram.GetBuckets()))
+	for _, bound := range config.SizeHistogram.GetBuckets() {
+		bounds = append(bounds, float64(bound))
+	}
+	return &sizeHistogramCollector{
+		desc: prometheus.NewDesc(prometheus.BuildFQName(config.MetricNamespace, config.MetricSubsystem, name),
+			"Blob sizes of the "+familyName+" metric", labelNames, nil),
+		labelNames: labelNames,
+		bounds:     bounds,
+	}
+}
+
+func (c *sizeHistogramCollector) set(aggregationResults []agg.AggregationResult) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.aggregationResults = aggregationResults
+}
+
+func (c *sizeHistogramCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+func (c *sizeHistogramCollector) Collect(ch chan<- prometheus.Metric) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	for _, aggregationResult := range c.aggregationResults {
+		if len(aggregationResult.SizeBuckets) != len(c.bounds)+1 {
+			continue
+		}
+		labels := aggregationGroupToLabels(aggregationResult.AggregationGroup)
+		labelValues := make([]string, len(c.labelNames))
+		for i, labelName := range c.labelNames {
+			labelValues[i] = labels[labelName]
+		}
+		ch <- prometheus.MustNewConstHistogram(c.desc, uint64(aggregationResult.Count), float64(aggregationResult.StorageUsage),
+			cumulativeBuckets(c.bounds, aggregationResult.SizeBuckets), labelValues...)
+	}
+}
+
+func cumulativeBuckets(bounds []float64, sizeBuckets du.SizeBuckets) map[float64]uint64 {
+	buckets := make(map[float64]uint64, len(bounds))
+	var cumulative uint64
+	for i, bound := range bounds {
+		cumulative += uint64(sizeBuckets[i])
+		buckets[bound] = cumulative
+	}
+	return buckets
+}
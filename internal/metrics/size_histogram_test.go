@@ -0,0 +1,37 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/PDOK/azure-storage-usage-exporter/internal/agg"
+	"github.com/PDOK/azure-storage-usage-exporter/internal/du"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_sizeHistogramCollector(t *testing.T) {
+	config := Config{MetricNamespace: "azure", MetricSubsystem: "storage", SizeHistogram: &SizeHistogramConfig{Buckets: []du.ByteSize{1024, 1 << 20}}}
+	collector := newSizeHistogramCollector(config, DefaultFamily, []string{"tenant", agg.Deleted})
+	collector.set([]agg.AggregationResult{
+		{AggregationGroup: agg.AggregationGroup{Labels: agg.Labels{"tenant": "foo"}}, StorageUsage: 3000000, Count: 6, SizeBuckets: []int64{3, 2, 1}},
+		{AggregationGroup: agg.AggregationGroup{Labels: agg.Labels{"tenant": "bar"}}, StorageUsage: 10, Count: 1}, // no size buckets
+	})
+	err := testutil.CollectAndCompare(collector, strings.NewReader(`
+# HELP azure_storage_blob_size_bytes Blob sizes of the usage metric
+# TYPE azure_storage_blob_size_bytes histogram
+azure_storage_blob_size_bytes_bucket{deleted="false",tenant="foo",le="1024"} 3
+azure_storage_blob_size_bytes_bucket{deleted="false",tenant="foo",le="1.048576e+06"} 5
+azure_storage_blob_size_bytes_bucket{deleted="false",tenant="foo",le="+Inf"} 6
+azure_storage_blob_size_bytes_sum{deleted="false",tenant="foo"} 3e+06
+azure_storage_blob_size_bytes_count{deleted="false",tenant="foo"} 6
+`))
+	require.NoError(t, err)
+}
+
+func TestSizeHistogramConfig_Validate(t *testing.T) {
+	assert.Empty(t, (&SizeHistogramConfig{}).Validate())
+	assert.Len(t, (&SizeHistogramConfig{Buckets: []du.ByteSize{1024, 1024}}).Validate(), 1)
+	assert.Len(t, (&SizeHistogramConfig{Buckets: []du.ByteSize{1024, 10}}).Validate(), 1)
+}
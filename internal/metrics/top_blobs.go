@@ -0,0 +1,100 @@
+package metrics
+
+import (
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/PDOK/azure-storage-usage-exporter/internal/agg"
+	"github.com/PDOK/azure-storage-usage-exporter/internal/du"
+	"github.com/creasty/defaults"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+type TopBlobsConfig struct {
+	// Count is the number of largest blobs to query (and serve in the API)
+	Count int `yaml:"count" default:"100"`
+	// GaugeCount is the number of largest blobs to export as metric, 0 means none
+	GaugeCount int `yaml:"gaugeCount"`
+}
+
+type unmarshalledTopBlobsConfig TopBlobsConfig
+
+func (t *TopBlobsConfig) UnmarshalYAML(unmarshal func(any) error) error {
+	tmp := new(unmarshalledTopBlobsConfig)
+	if err := defaults.Set(tmp); err != nil {
+		return err
+	}
+	if err := unmarshal(tmp); err != nil {
+		return err
+	}
+	*t = TopBlobsConfig(*tmp)
+	return nil
+}
+
+type topBlobs struct {
+	config TopBlobsConfig
+	reader du.LargestBlobsReader
+	gauge  *prometheus.GaugeVec // nil when GaugeCount is 0
+
+	mu      sync.RWMutex
+	runDate time.Time
+	blobs   []du.Blob
+}
+
+// WithLargestBlobs makes the Updater also query the largest blobs after every run, when Config.TopBlobs is given
+func (ms *Updater) WithLargestBlobs(reader du.LargestBlobsReader) *Updater {
+	if ms.config.TopBlobs == nil {
+		return ms
+	}
+	ms.topBlobs = &topBlobs{config: *ms.config.TopBlobs, reader: reader}
+	if ms.config.TopBlobs.GaugeCount > 0 {
+		constLabels := prometheus.Labels{}
+		if storageAccountName := ms.families[0].Aggregator.GetStorageAccountName(); storageAccountName != "" {
+			constLabels[agg.StorageAccount] = storageAccountName
+		}
+		ms.topBlobs.gauge = promauto.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace:   ms.config.MetricNamespace,
+			Subsystem:   ms.config.MetricSubsystem,
+			Name:        "top_blob_bytes",
+			Help:        "Bytes of the largest individual blobs",
+			ConstLabels: constLabels,
+		}, []string{"blob", "access_tier", agg.Deleted})
+	}
+	return ms
+}
+
+// update queries the largest blobs of the run. Failing is logged, it doesn't fail the whole update.
+func (t *topBlobs) update(runDate time.Time) {
+	blobs, err := t.reader.ReadLargestBlobs(runDate, t.config.Count)
+	if err != nil {
+		log.Printf("querying largest blobs failed: %s", err)
+		return
+	}
+	if t.gauge != nil {
+		t.gauge.Reset()
+		for i, blob := range blobs {
+			if i >= t.config.GaugeCount {
+				break
+			}
+			t.gauge.WithLabelValues(blob.Name, blob.AccessTier, strconv.FormatBool(blob.Deleted)).Set(float64(blob.Size))
+		}
+	}
+	t.mu.Lock()
+	t.runDate = runDate
+	t.blobs = blobs
+	t.mu.Unlock()
+}
+
+// GetLastLargestBlobs returns the largest blobs of the last processed run,
+// ok is false when top blobs are not configured
+func (ms *Updater) GetLastLargestBlobs() (runDate time.Time, blobs []du.Blob, ok bool) {
+	if ms.topBlobs == nil {
+		return time.Time{}, nil, false
+	}
+	ms.topBlobs.mu.RLock()
+	defer ms.topBlobs.mu.RUnlock()
+	return ms.topBlobs.runDate, ms.topBlobs.blobs, true
+}
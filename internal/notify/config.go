@@ -0,0 +1,122 @@
+package notify
+
+import (
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/PDOK/azure-storage-usage-exporter/internal/agg"
+	"github.com/PDOK/azure-storage-usage-exporter/internal/du"
+	"github.com/creasty/defaults"
+)
+
+type Format string
+
+const (
+	FormatJSON       Format = "json"
+	FormatCloudEvent Format = "cloudevent"
+)
+
+type Config struct {
+	Webhooks   []WebhookConfig `yaml:"webhooks"`
+	Conditions []Condition     `yaml:"conditions"`
+}
+
+type WebhookConfig struct {
+	URL     string            `yaml:"url"`
+	Format  Format            `yaml:"format" default:"json"`
+	Headers map[string]string `yaml:"headers,omitempty"`
+	// Retries is the number of retries after a failed POST, RetryDelay doubles after every retry
+	Retries    int           `yaml:"retries" default:"3"`
+	RetryDelay time.Duration `yaml:"retryDelay" default:"10s"`
+	Timeout    time.Duration `yaml:"timeout" default:"10s"`
+}
+
+type unmarshalledWebhookConfig WebhookConfig
+
+func (w *WebhookConfig) UnmarshalYAML(unmarshal func(any) error) error {
+	tmp := new(unmarshalledWebhookConfig)
+	if err := defaults.Set(tmp); err != nil {
+		return err
+	}
+	if err := unmarshal(tmp); err != nil {
+		return err
+	}
+	*w = WebhookConfig(*tmp)
+	return nil
+}
+
+// Condition is checked for every aggregation group (or quota) of a metric after every run.
+// Exactly one of the thresholds must be given.
+type Condition struct {
+	Name   string `yaml:"name"`
+	Metric string `yaml:"metric" default:"usage"`
+	// Labels optionally restrict the condition to the aggregation groups (or quotas) with these labels
+	Labels agg.Labels `yaml:"labels,omitempty"`
+
+	// MinBytes triggers when an aggregation group is at least this large
+	MinBytes *du.ByteSize `yaml:"minBytes,omitempty"`
+	// MinGrowthBytes triggers when an aggregation group grew at least this much since the previous run
+	MinGrowthBytes *du.ByteSize `yaml:"minGrowthBytes,omitempty"`
+	// MinGrowthRatio triggers when an aggregation group grew at least this fraction since the previous run (e.g. 0.1 for 10%)
+	MinGrowthRatio *float64 `yaml:"minGrowthRatio,omitempty"`
+	// MinQuotaUtilization triggers when a quota is used at least this fraction (e.g. 1 for a breach)
+	MinQuotaUtilization *float64 `yaml:"minQuotaUtilization,omitempty"`
+}
+
+type unmarshalledCondition Condition
+
+func (c *Condition) UnmarshalYAML(unmarshal func(any) error) error {
+	tmp := new(unmarshalledCondition)
+	if err := defaults.Set(tmp); err != nil {
+		return err
+	}
+	if err := unmarshal(tmp); err != nil {
+		return err
+	}
+	*c = Condition(*tmp)
+	return nil
+}
+
+// Validate checks the webhooks and conditions, familyNames are the names of the existing metrics
+func (c *Config) Validate(familyNames []string) []agg.Issue {
+	if c == nil {
+		return nil
+	}
+	var issues []agg.Issue
+	for i, webhook := range c.Webhooks {
+		if webhook.URL == "" {
+			issues = append(issues, agg.Issue{Severity: agg.SeverityError, Message: fmt.Sprintf("webhook %d has no url", i)})
+		}
+		if webhook.Format != FormatJSON && webhook.Format != FormatCloudEvent {
+			issues = append(issues, agg.Issue{Severity: agg.SeverityError, Message: fmt.Sprintf("webhook %d has unknown format %q, use %s or %s", i, webhook.Format, FormatJSON, FormatCloudEvent)})
+		}
+	}
+	if len(c.Conditions) > 0 && len(c.Webhooks) == 0 {
+		issues = append(issues, agg.Issue{Severity: agg.SeverityWarning, Message: "notification conditions are configured without webhooks"})
+	}
+	var names []string
+	for i, condition := range c.Conditions {
+		if condition.Name == "" || slices.Contains(names, condition.Name) {
+			issues = append(issues, agg.Issue{Severity: agg.SeverityError, Message: fmt.Sprintf("condition %d needs a unique name", i)})
+		}
+		names = append(names, condition.Name)
+		if !slices.Contains(familyNames, condition.Metric) {
+			issues = append(issues, agg.Issue{Severity: agg.SeverityError, Message: fmt.Sprintf("condition %s: unknown metric %q", condition.Name, condition.Metric)})
+		}
+		if condition.countThresholds() != 1 {
+			issues = append(issues, agg.Issue{Severity: agg.SeverityError, Message: fmt.Sprintf("condition %s needs exactly one of minBytes, minGrowthBytes, minGrowthRatio or minQuotaUtilization", condition.Name)})
+		}
+	}
+	return issues
+}
+
+func (c Condition) countThresholds() int {
+	count := 0
+	for _, isSet := range []bool{c.MinBytes != nil, c.MinGrowthBytes != nil, c.MinGrowthRatio != nil, c.MinQuotaUtilization != nil} {
+		if isSet {
+			count++
+		}
+	}
+	return count
+}
@@ -0,0 +1,200 @@
+// Package notify sends webhook notifications when the aggregation results of a run meet configured conditions
+package notify
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"time"
+
+	"github.com/PDOK/azure-storage-usage-exporter/internal/agg"
+	"github.com/PDOK/azure-storage-usage-exporter/internal/du"
+	"github.com/PDOK/azure-storage-usage-exporter/internal/metrics"
+	"github.com/PDOK/azure-storage-usage-exporter/internal/report"
+)
+
+// Event is the (stable) JSON representation of a triggered condition
+type Event struct {
+	Condition string     `json:"condition"`
+	Metric    string     `json:"metric"`
+	RunDate   time.Time  `json:"runDate"`
+	Labels    agg.Labels `json:"labels"`
+	// Deleted is absent for quota conditions
+	Deleted              *bool            `json:"deleted,omitempty"`
+	StorageUsage         du.StorageUsage  `json:"storageUsage"`
+	PreviousStorageUsage *du.StorageUsage `json:"previousStorageUsage,omitempty"`
+	QuotaBytes           *du.StorageUsage `json:"quotaBytes,omitempty"`
+	Message              string           `json:"message"`
+
+	key string // identifies the condition and aggregation group (or quota), for deduplication
+}
+
+// Source provides the results of the last processed run, see metrics.Updater
+type Source interface {
+	GetLastAggregationResults(familyName string) (runDate time.Time, aggregationResults []agg.AggregationResult, ok bool)
+	GetLastQuotaUsages() []metrics.QuotaUsage
+}
+
+// Notifier checks the conditions after every run and posts the events to the webhooks.
+// An event is only sent once, until its condition no longer holds.
+type Notifier struct {
+	config             Config
+	storageAccountName string
+	webhooks           []*webhook
+
+	lastRunDate time.Time
+	// previous holds the storage usage per aggregation group (key) per metric of the previous run, to compute growth
+	previous map[string]map[string]du.StorageUsage
+	// active holds the keys of the events that are sent and still hold
+	active map[string]bool
+}
+
+func NewNotifier(config Config, storageAccountName string) *Notifier {
+	webhooks := make([]*webhook, len(config.Webhooks))
+	for i, webhookConfig := range config.Webhooks {
+		webhooks[i] = newWebhook(webhookConfig)
+	}
+	return &Notifier{
+		config:             config,
+		storageAccountName: storageAccountName,
+		webhooks:           webhooks,
+		previous:           make(map[string]map[string]du.StorageUsage),
+		active:             make(map[string]bool),
+	}
+}
+
+// Notify checks the conditions against the last processed run (if it wasn't checked before)
+// and sends the events that weren't sent before. Events that failed to be sent are retried after the next run.
+func (n *Notifier) Notify(source Source) error {
+	runDate, _, _ := source.GetLastAggregationResults(metrics.DefaultFamily)
+	if runDate.IsZero() || runDate.Equal(n.lastRunDate) {
+		return nil
+	}
+	n.lastRunDate = runDate
+
+	events := n.evaluate(source)
+	active := make(map[string]bool, len(events))
+	var errs []error
+	for _, event := range events {
+		if n.active[event.key] {
+			active[event.key] = true
+			continue
+		}
+		log.Printf("notifying %s", event.Message)
+		if err := n.send(event); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		active[event.key] = true
+	}
+	n.active = active
+	return errors.Join(errs...)
+}
+
+func (n *Notifier) send(event Event) error {
+	var errs []error
+	for _, webhook := range n.webhooks {
+		if err := webhook.post(event, n.storageAccountName); err != nil {
+			errs = append(errs, fmt.Errorf("notifying %s failed: %w", event.Condition, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// evaluate checks all conditions and remembers the current storage usage for the next run
+func (n *Notifier) evaluate(source Source) []Event {
+	var events []Event
+	current := make(map[string]map[string]du.StorageUsage)
+	for _, condition := range n.config.Conditions {
+		if condition.MinQuotaUtilization != nil {
+			events = append(events, evaluateQuotaCondition(condition, n.lastRunDate, source.GetLastQuotaUsages())...)
+			continue
+		}
+		_, aggregationResults, _ := source.GetLastAggregationResults(condition.Metric)
+		if _, done := current[condition.Metric]; !done {
+			current[condition.Metric] = make(map[string]du.StorageUsage, len(aggregationResults))
+			for _, aggregationResult := range aggregationResults {
+				current[condition.Metric][aggregationResult.AggregationGroup.Key()] = aggregationResult.StorageUsage
+			}
+		}
+		for _, aggregationResult := range aggregationResults {
+			if !matchesLabels(condition.Labels, aggregationResult.AggregationGroup.Labels) {
+				continue
+			}
+			previous, hasPrevious := n.previous[condition.Metric][aggregationResult.AggregationGroup.Key()]
+			if event, triggered := evaluateCondition(condition, aggregationResult, previous, hasPrevious); triggered {
+				event.RunDate = n.lastRunDate
+				events = append(events, event)
+			}
+		}
+	}
+	maps.Copy(n.previous, current)
+	return events
+}
+
+func evaluateCondition(condition Condition, aggregationResult agg.AggregationResult, previous du.StorageUsage, hasPrevious bool) (Event, bool) {
+	group := aggregationResult.AggregationGroup
+	usage := aggregationResult.StorageUsage
+	event := Event{
+		Condition:    condition.Name,
+		Metric:       condition.Metric,
+		Labels:       group.Labels,
+		Deleted:      &group.Deleted,
+		StorageUsage: usage,
+		key:          condition.Name + group.Key(),
+	}
+	description := fmt.Sprintf("%s %v (deleted: %t)", condition.Metric, group.Labels, group.Deleted)
+	switch {
+	case condition.MinBytes != nil:
+		event.Message = fmt.Sprintf("%s: %s is %s", condition.Name, description, report.HumanReadableBytes(usage))
+		return event, usage >= du.StorageUsage(*condition.MinBytes)
+	case !hasPrevious:
+		return event, false
+	case condition.MinGrowthBytes != nil:
+		event.PreviousStorageUsage = &previous
+		event.Message = fmt.Sprintf("%s: %s grew %s to %s", condition.Name, description, report.HumanReadableBytes(usage-previous), report.HumanReadableBytes(usage))
+		return event, usage-previous >= du.StorageUsage(*condition.MinGrowthBytes)
+	case condition.MinGrowthRatio != nil:
+		event.PreviousStorageUsage = &previous
+		growthRatio := float64(usage-previous) / float64(previous)
+		event.Message = fmt.Sprintf("%s: %s grew %.1f%% to %s", condition.Name, description, 100*growthRatio, report.HumanReadableBytes(usage))
+		return event, previous > 0 && growthRatio >= *condition.MinGrowthRatio
+	}
+	return event, false
+}
+
+func evaluateQuotaCondition(condition Condition, runDate time.Time, quotaUsages []metrics.QuotaUsage) []Event {
+	var events []Event
+	for _, quotaUsage := range quotaUsages {
+		if quotaUsage.Quota.Metric != condition.Metric || !matchesLabels(condition.Labels, quotaUsage.Quota.Labels) {
+			continue
+		}
+		if quotaUsage.Utilization() < *condition.MinQuotaUtilization {
+			continue
+		}
+		quotaBytes := du.StorageUsage(quotaUsage.Quota.Limit)
+		events = append(events, Event{
+			Condition:    condition.Name,
+			Metric:       condition.Metric,
+			RunDate:      runDate,
+			Labels:       quotaUsage.Quota.Labels,
+			StorageUsage: quotaUsage.StorageUsage,
+			QuotaBytes:   &quotaBytes,
+			Message: fmt.Sprintf("%s: quota %s %v is %.1f%% used (%s of %s)", condition.Name, condition.Metric, quotaUsage.Quota.Labels,
+				100*quotaUsage.Utilization(), report.HumanReadableBytes(quotaUsage.StorageUsage), report.HumanReadableBytes(quotaBytes)),
+			key: condition.Name + agg.AggregationGroup{Labels: quotaUsage.Quota.Labels}.Key(),
+		})
+	}
+	return events
+}
+
+// matchesLabels reports whether labels has all the wanted labels
+func matchesLabels(wanted, labels agg.Labels) bool {
+	for labelName, labelValue := range wanted {
+		if labels[labelName] != labelValue {
+			return false
+		}
+	}
+	return true
+}
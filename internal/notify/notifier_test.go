@@ -0,0 +1,98 @@
+package notify
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/PDOK/azure-storage-usage-exporter/internal/agg"
+	"github.com/PDOK/azure-storage-usage-exporter/internal/du"
+	"github.com/PDOK/azure-storage-usage-exporter/internal/metrics"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNotifier_Notify(t *testing.T) {
+	someFixedTime, _ := time.Parse(time.DateOnly, "2024-04-20")
+	var mu sync.Mutex
+	var received []map[string]any
+	failNext := true
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if failNext { // the first attempt fails, to test retrying
+			failNext = false
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		assert.Equal(t, "application/cloudevents+json", r.Header.Get("Content-Type"))
+		var body map[string]any
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		received = append(received, body)
+	}))
+	defer server.Close()
+
+	minBytes := du.ByteSize(1000)
+	minGrowthRatio := 0.5
+	notifier := NewNotifier(Config{
+		Webhooks: []WebhookConfig{{URL: server.URL, Format: FormatCloudEvent, Retries: 1, RetryDelay: time.Millisecond, Timeout: time.Second}},
+		Conditions: []Condition{
+			{Name: "large", Metric: metrics.DefaultFamily, MinBytes: &minBytes},
+			{Name: "growing", Metric: metrics.DefaultFamily, Labels: agg.Labels{"tenant": "foo"}, MinGrowthRatio: &minGrowthRatio},
+		},
+	}, "faker")
+	receivedSubjects := func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		var subjects []string
+		for _, body := range received {
+			subjects = append(subjects, body["subject"].(string)+" "+body["data"].(map[string]any)["labels"].(map[string]any)["tenant"].(string))
+		}
+		received = nil
+		return subjects
+	}
+
+	source := &fakeSource{runDate: someFixedTime, results: []agg.AggregationResult{
+		{AggregationGroup: agg.AggregationGroup{Labels: agg.Labels{"tenant": "foo"}}, StorageUsage: 600},
+		{AggregationGroup: agg.AggregationGroup{Labels: agg.Labels{"tenant": "bar"}}, StorageUsage: 2000},
+	}}
+	require.NoError(t, notifier.Notify(source))
+	assert.Equal(t, []string{"large bar"}, receivedSubjects())
+
+	// same run again
+	require.NoError(t, notifier.Notify(source))
+	assert.Empty(t, receivedSubjects())
+
+	// next run, bar is still large (so not notified again), foo grew
+	source.runDate = someFixedTime.Add(24 * time.Hour)
+	source.results[0].StorageUsage = 900
+	require.NoError(t, notifier.Notify(source))
+	assert.Equal(t, []string{"growing foo"}, receivedSubjects())
+
+	// next run, foo is large, bar is no longer large, and then large again
+	source.runDate = someFixedTime.Add(48 * time.Hour)
+	source.results[0].StorageUsage = 1000
+	source.results[1].StorageUsage = 10
+	require.NoError(t, notifier.Notify(source))
+	assert.Equal(t, []string{"large foo"}, receivedSubjects())
+	source.runDate = someFixedTime.Add(72 * time.Hour)
+	source.results[1].StorageUsage = 1000
+	require.NoError(t, notifier.Notify(source))
+	assert.Equal(t, []string{"large bar"}, receivedSubjects())
+}
+
+type fakeSource struct {
+	runDate time.Time
+	results []agg.AggregationResult
+}
+
+func (f *fakeSource) GetLastAggregationResults(_ string) (time.Time, []agg.AggregationResult, bool) {
+	return f.runDate, f.results, true
+}
+
+func (f *fakeSource) GetLastQuotaUsages() []metrics.QuotaUsage {
+	return nil
+}
@@ -0,0 +1,95 @@
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	cloudEventType    = "nl.pdok.azure-storage-usage-exporter.threshold"
+	cloudEventSource  = "azure-storage-usage-exporter"
+	cloudEventVersion = "1.0"
+)
+
+// cloudEvent is a CloudEvent (v1.0) in structured content mode
+type cloudEvent struct {
+	SpecVersion     string    `json:"specversion"`
+	ID              string    `json:"id"`
+	Source          string    `json:"source"`
+	Type            string    `json:"type"`
+	Subject         string    `json:"subject"`
+	Time            time.Time `json:"time"`
+	DataContentType string    `json:"datacontenttype"`
+	Data            Event     `json:"data"`
+}
+
+type webhook struct {
+	config WebhookConfig
+	client *http.Client
+}
+
+func newWebhook(config WebhookConfig) *webhook {
+	return &webhook{config: config, client: &http.Client{Timeout: config.Timeout}}
+}
+
+// post sends the event, retrying on connection errors and 5xx and 429 responses
+func (w *webhook) post(event Event, storageAccountName string) error {
+	contentType := "application/json"
+	var payload any = event
+	if w.config.Format == FormatCloudEvent {
+		contentType = "application/cloudevents+json"
+		source := cloudEventSource
+		if storageAccountName != "" {
+			source += "/" + storageAccountName
+		}
+		payload = cloudEvent{
+			SpecVersion:     cloudEventVersion,
+			ID:              uuid.NewString(),
+			Source:          source,
+			Type:            cloudEventType,
+			Subject:         event.Condition,
+			Time:            time.Now().UTC(),
+			DataContentType: "application/json",
+			Data:            event,
+		}
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	delay := w.config.RetryDelay
+	for attempt := 0; ; attempt++ {
+		retryable, err := w.postOnce(body, contentType)
+		if err == nil || !retryable || attempt >= w.config.Retries {
+			return err
+		}
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+func (w *webhook) postOnce(body []byte, contentType string) (retryable bool, err error) {
+	request, err := http.NewRequest(http.MethodPost, w.config.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	request.Header.Set("Content-Type", contentType)
+	for name, value := range w.config.Headers {
+		request.Header.Set(name, value)
+	}
+	response, err := w.client.Do(request)
+	if err != nil {
+		return true, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode >= http.StatusBadRequest {
+		retryable = response.StatusCode >= http.StatusInternalServerError || response.StatusCode == http.StatusTooManyRequests
+		return retryable, fmt.Errorf("webhook %s responded with status %s", w.config.URL, response.Status)
+	}
+	return false, nil
+}
@@ -0,0 +1,63 @@
+package report
+
+import (
+	"encoding/json"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/PDOK/azure-storage-usage-exporter/internal/du"
+)
+
+// TopBlobs is the (stable) JSON representation of the largest blobs of a run
+type TopBlobs struct {
+	RunDate time.Time   `json:"runDate"`
+	Blobs   []BlobEntry `json:"blobs"`
+}
+
+// BlobEntry is the (stable) JSON representation of a single du.Blob
+type BlobEntry struct {
+	Name         string          `json:"name"`
+	Bytes        du.StorageUsage `json:"bytes"`
+	AccessTier   string          `json:"accessTier,omitempty"`
+	LastModified *time.Time      `json:"lastModified,omitempty"`
+	Deleted      bool            `json:"deleted"`
+}
+
+func NewTopBlobs(runDate time.Time, blobs []du.Blob) TopBlobs {
+	entries := make([]BlobEntry, len(blobs))
+	for i, blob := range blobs {
+		entries[i] = BlobEntry{Name: blob.Name, Bytes: blob.Size, AccessTier: blob.AccessTier, LastModified: blob.LastModified, Deleted: blob.Deleted}
+	}
+	return TopBlobs{RunDate: runDate, Blobs: entries}
+}
+
+// WriteTopBlobs renders the largest blobs
+func WriteTopBlobs(w io.Writer, topBlobs TopBlobs, options Options) error {
+	blobs := topBlobs.Blobs
+	if options.Top > 0 && len(blobs) > options.Top {
+		blobs = blobs[:options.Top]
+	}
+	if options.Format == FormatJSON {
+		topBlobs.Blobs = blobs
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(topBlobs)
+	}
+	header := []string{"name", bytesColumn, "access tier", "last modified", "deleted"}
+	records := make([][]string, len(blobs))
+	for i, blob := range blobs {
+		lastModified := ""
+		if blob.LastModified != nil {
+			lastModified = blob.LastModified.UTC().Format(time.RFC3339)
+		}
+		records[i] = []string{
+			blob.Name,
+			formatBytes(blob.Bytes, options.HumanReadable && options.Format != FormatCSV),
+			blob.AccessTier,
+			lastModified,
+			strconv.FormatBool(blob.Deleted),
+		}
+	}
+	return writeRecords(w, options.Format, header, []string{"---", "---:", "---", "---", "---"}, records)
+}
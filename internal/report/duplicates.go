@@ -0,0 +1,70 @@
+package report
+
+import (
+	"encoding/json"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/PDOK/azure-storage-usage-exporter/internal/agg"
+	"github.com/PDOK/azure-storage-usage-exporter/internal/du"
+)
+
+// Duplicates is the (stable) JSON representation of du.Duplicates
+type Duplicates struct {
+	RunDate               time.Time           `json:"runDate"`
+	TotalReclaimableBytes du.StorageUsage     `json:"totalReclaimableBytes"`
+	Sets                  []DuplicateSetEntry `json:"sets"`
+	// Results are the reclaimable bytes per aggregation group
+	Results []UsageEntry `json:"results,omitempty"`
+}
+
+// DuplicateSetEntry is the (stable) JSON representation of a single du.DuplicateSet
+type DuplicateSetEntry struct {
+	MD5              string          `json:"md5"`
+	Bytes            du.StorageUsage `json:"bytes"`
+	Count            int64           `json:"count"`
+	ReclaimableBytes du.StorageUsage `json:"reclaimableBytes"`
+	Names            []string        `json:"names"`
+}
+
+func NewDuplicates(runDate time.Time, duplicates du.Duplicates, aggregationResults []agg.AggregationResult) Duplicates {
+	sets := make([]DuplicateSetEntry, len(duplicates.Sets))
+	for i, set := range duplicates.Sets {
+		sets[i] = DuplicateSetEntry{MD5: set.MD5, Bytes: set.Size, Count: set.Count, ReclaimableBytes: set.ReclaimableBytes, Names: set.Names}
+	}
+	return Duplicates{
+		RunDate:               runDate,
+		TotalReclaimableBytes: duplicates.TotalReclaimableBytes,
+		Sets:                  sets,
+		Results:               NewUsage(runDate, aggregationResults).Results,
+	}
+}
+
+// WriteDuplicates renders the largest duplicate sets
+func WriteDuplicates(w io.Writer, duplicates Duplicates, options Options) error {
+	sets := duplicates.Sets
+	if options.Top > 0 && len(sets) > options.Top {
+		sets = sets[:options.Top]
+	}
+	if options.Format == FormatJSON {
+		duplicates.Sets = sets
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(duplicates)
+	}
+	humanReadable := options.HumanReadable && options.Format != FormatCSV
+	header := []string{"md5", bytesColumn, "count", "reclaimable bytes", "names"}
+	records := make([][]string, len(sets))
+	for i, set := range sets {
+		records[i] = []string{
+			set.MD5,
+			formatBytes(set.Bytes, humanReadable),
+			strconv.FormatInt(set.Count, 10),
+			formatBytes(set.ReclaimableBytes, humanReadable),
+			strings.Join(set.Names, " "),
+		}
+	}
+	return writeRecords(w, options.Format, header, []string{"---", "---:", "---:", "---:", "---"}, records)
+}
@@ -0,0 +1,67 @@
+package report
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+
+	"github.com/PDOK/azure-storage-usage-exporter/internal/agg"
+	"golang.org/x/exp/maps"
+)
+
+// WriteExplanation renders an agg.Explanation as readable text
+func WriteExplanation(w io.Writer, explanation agg.Explanation) error {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "dir: %s (deleted: %t)\n\nrules:\n", explanation.Dir, explanation.Deleted)
+	for _, trial := range explanation.Trials {
+		outcome := "no match"
+		if trial.ConditionsNotMet {
+			outcome = "dir matches, but the blob attributes don't meet the conditions"
+		}
+		if trial.Matched {
+			outcome = "MATCH, groups: " + formatLabels(trial.Groups)
+			if trial.Prefix != "" {
+				outcome = fmt.Sprintf("MATCH prefix %q, labels: %s", trial.Prefix, formatLabels(trial.Groups))
+			}
+		}
+		fmt.Fprintf(&sb, "  %d. %s => %s\n", trial.Index, trial.Pattern, outcome)
+	}
+	if explanation.MatchedRule < 0 {
+		sb.WriteString("  no rule matched, using label defaults\n")
+	}
+	if explanation.Excluded {
+		fmt.Fprintf(&sb, "\nexcluded: rule %d drops this dir, so it's not in the metric\n", explanation.MatchedRule)
+		_, err := io.WriteString(w, sb.String())
+		return err
+	}
+	sb.WriteString("\nlabels:\n")
+	labelNames := maps.Keys(explanation.Labels)
+	slices.Sort(labelNames)
+	for _, labelName := range labelNames {
+		label := explanation.Labels[labelName]
+		fmt.Fprintf(&sb, "  %s=%q (from %s)\n", labelName, label.Value, label.Source)
+	}
+	if len(explanation.Split) > 0 {
+		sb.WriteString("\nsplit over aggregation groups:\n")
+		for _, share := range explanation.Split {
+			fmt.Fprintf(&sb, "  %.1f%%: %s, deleted=%t\n", 100*share.Fraction,
+				formatLabels(share.AggregationGroup.Labels), share.AggregationGroup.Deleted)
+		}
+	} else {
+		fmt.Fprintf(&sb, "\naggregation group: %s, deleted=%t\n",
+			formatLabels(explanation.AggregationGroup.Labels), explanation.AggregationGroup.Deleted)
+	}
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+func formatLabels(labels agg.Labels) string {
+	labelNames := maps.Keys(labels)
+	slices.Sort(labelNames)
+	formatted := make([]string, len(labelNames))
+	for i, labelName := range labelNames {
+		formatted[i] = fmt.Sprintf("%s=%q", labelName, labels[labelName])
+	}
+	return "{" + strings.Join(formatted, ", ") + "}"
+}
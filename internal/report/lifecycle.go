@@ -0,0 +1,129 @@
+package report
+
+import (
+	"encoding/json"
+	"io"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/PDOK/azure-storage-usage-exporter/internal/agg"
+	"github.com/PDOK/azure-storage-usage-exporter/internal/du"
+	"github.com/PDOK/azure-storage-usage-exporter/internal/lifecycle"
+)
+
+// LifecycleSimulation is the (stable) JSON representation of the effects of a lifecycle policy on a run
+type LifecycleSimulation struct {
+	RunDate time.Time `json:"runDate"`
+	// Totals are the bytes and blobs per action
+	Totals  []LifecycleEffectEntry `json:"totals"`
+	Effects []LifecycleEffectEntry `json:"effects"`
+}
+
+// LifecycleEffectEntry is the (stable) JSON representation of a single lifecycle.Effect.
+// The costs are only present when pricing is configured.
+type LifecycleEffectEntry struct {
+	Labels     agg.Labels       `json:"labels,omitempty"`
+	Action     lifecycle.Action `json:"action"`
+	Bytes      du.StorageUsage  `json:"bytes"`
+	Count      int64            `json:"count"`
+	CostBefore *float64         `json:"estimatedMonthlyCostBefore,omitempty"`
+	CostAfter  *float64         `json:"estimatedMonthlyCostAfter,omitempty"`
+}
+
+func NewLifecycleSimulation(runDate time.Time, effects []lifecycle.Effect, withCost bool) LifecycleSimulation {
+	simulation := LifecycleSimulation{RunDate: runDate, Totals: []LifecycleEffectEntry{}, Effects: make([]LifecycleEffectEntry, len(effects))}
+	totalIndexes := make(map[lifecycle.Action]int)
+	for i, effect := range effects {
+		simulation.Effects[i] = LifecycleEffectEntry{
+			Labels: effect.AggregationGroup.Labels,
+			Action: effect.Action,
+			Bytes:  effect.StorageUsage,
+			Count:  effect.Count,
+		}
+		totalIndex, exists := totalIndexes[effect.Action]
+		if !exists {
+			totalIndex = len(simulation.Totals)
+			totalIndexes[effect.Action] = totalIndex
+			simulation.Totals = append(simulation.Totals, LifecycleEffectEntry{Action: effect.Action})
+			if withCost {
+				simulation.Totals[totalIndex].CostBefore, simulation.Totals[totalIndex].CostAfter = new(float64), new(float64)
+			}
+		}
+		total := &simulation.Totals[totalIndex]
+		total.Bytes += effect.StorageUsage
+		total.Count += effect.Count
+		if withCost {
+			simulation.Effects[i].CostBefore, simulation.Effects[i].CostAfter = &effect.CostBefore, &effect.CostAfter
+			*total.CostBefore += effect.CostBefore
+			*total.CostAfter += effect.CostAfter
+		}
+	}
+	return simulation
+}
+
+// WriteLifecycleSimulation renders the bytes (and cost) per action and aggregation group, largest first per action.
+// Top limits the effects per action, not the totals.
+func WriteLifecycleSimulation(w io.Writer, simulation LifecycleSimulation, options Options) error {
+	effects := simulation.Effects
+	if options.Top > 0 {
+		effects = nil
+		countPerAction := make(map[lifecycle.Action]int)
+		for _, effect := range simulation.Effects {
+			if countPerAction[effect.Action] < options.Top {
+				effects = append(effects, effect)
+			}
+			countPerAction[effect.Action]++
+		}
+	}
+	if options.Format == FormatJSON {
+		simulation.Effects = effects
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(simulation)
+	}
+	var labelNames []string
+	for _, effect := range effects {
+		for labelName := range effect.Labels {
+			if !slices.Contains(labelNames, labelName) {
+				labelNames = append(labelNames, labelName)
+			}
+		}
+	}
+	slices.Sort(labelNames)
+	withCost := len(simulation.Totals) > 0 && simulation.Totals[0].CostBefore != nil
+
+	header := append(slices.Clone(labelNames), "action", bytesColumn, "count")
+	alignment := make([]string, len(labelNames), len(header)+3)
+	for i := range alignment {
+		alignment[i] = "---"
+	}
+	alignment = append(alignment, "---", "---:", "---:")
+	if withCost {
+		header = append(header, "cost before", "cost after", "cost difference")
+		alignment = append(alignment, "---:", "---:", "---:")
+	}
+	humanReadable := options.HumanReadable && options.Format != FormatCSV
+	var records [][]string
+	for _, entries := range [][]LifecycleEffectEntry{simulation.Totals, effects} {
+		for _, entry := range entries {
+			record := make([]string, len(labelNames), len(header))
+			for i, labelName := range labelNames {
+				record[i] = entry.Labels[labelName]
+			}
+			if entry.Labels == nil && len(labelNames) > 0 { // a total
+				record[0] = "(total)"
+			}
+			record = append(record, string(entry.Action), formatBytes(entry.Bytes, humanReadable), strconv.FormatInt(entry.Count, 10))
+			if withCost {
+				record = append(record, formatCost(*entry.CostBefore), formatCost(*entry.CostAfter), formatCost(*entry.CostAfter-*entry.CostBefore))
+			}
+			records = append(records, record)
+		}
+	}
+	return writeRecords(w, options.Format, header, alignment, records)
+}
+
+func formatCost(cost float64) string {
+	return strconv.FormatFloat(cost, 'f', 2, 64)
+}
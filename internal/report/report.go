@@ -0,0 +1,337 @@
+// Package report renders aggregation results for humans (and scripts) instead of Prometheus
+package report
+
+import (
+	"cmp"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/PDOK/azure-storage-usage-exporter/internal/agg"
+	"github.com/PDOK/azure-storage-usage-exporter/internal/du"
+)
+
+type Format string
+
+const (
+	FormatTable    Format = "table"
+	FormatCSV      Format = "csv"
+	FormatJSON     Format = "json"
+	FormatMarkdown Format = "markdown"
+
+	bytesColumn = "bytes"
+)
+
+var Formats = []Format{FormatTable, FormatCSV, FormatJSON, FormatMarkdown}
+
+func ParseFormat(s string) (Format, error) {
+	if !slices.Contains(Formats, Format(s)) {
+		return "", fmt.Errorf("unknown format %q, expected one of %v", s, Formats)
+	}
+	return Format(s), nil
+}
+
+// Usage is the (stable) JSON representation of aggregation results
+type Usage struct {
+	RunDate time.Time    `json:"runDate"`
+	Results []UsageEntry `json:"results"`
+}
+
+// UsageEntry is the (stable) JSON representation of a single agg.AggregationResult
+type UsageEntry struct {
+	Labels  agg.Labels      `json:"labels"`
+	Deleted bool            `json:"deleted"`
+	Bytes   du.StorageUsage `json:"bytes"`
+}
+
+func NewUsage(runDate time.Time, aggregationResults []agg.AggregationResult) Usage {
+	results := make([]UsageEntry, len(aggregationResults))
+	for i, aggregationResult := range aggregationResults {
+		results[i] = UsageEntry{
+			Labels:  aggregationResult.AggregationGroup.Labels,
+			Deleted: aggregationResult.AggregationGroup.Deleted,
+			Bytes:   aggregationResult.StorageUsage,
+		}
+	}
+	return Usage{RunDate: runDate, Results: results}
+}
+
+type Options struct {
+	Format Format
+	// Top limits the output to the largest results, 0 means no limit
+	Top int
+	// HumanReadable renders bytes as KiB, MiB, etc. (only for the table and markdown formats)
+	HumanReadable bool
+}
+
+// Write renders the aggregation results, largest first
+func Write(w io.Writer, runDate time.Time, aggregationResults []agg.AggregationResult, options Options) error {
+	aggregationResults = slices.Clone(aggregationResults)
+	slices.SortStableFunc(aggregationResults, func(a, b agg.AggregationResult) int {
+		return cmp.Compare(b.StorageUsage, a.StorageUsage)
+	})
+	if options.Top > 0 && len(aggregationResults) > options.Top {
+		aggregationResults = aggregationResults[:options.Top]
+	}
+
+	switch options.Format {
+	case FormatJSON:
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(NewUsage(runDate, aggregationResults))
+	case FormatCSV:
+		return writeCSV(w, aggregationResults)
+	case FormatTable:
+		return writeTable(w, aggregationResults, options.HumanReadable)
+	case FormatMarkdown:
+		return writeMarkdown(w, aggregationResults, options.HumanReadable)
+	}
+	return errors.New("unknown format: " + string(options.Format))
+}
+
+func writeCSV(w io.Writer, aggregationResults []agg.AggregationResult) error {
+	csvWriter := csv.NewWriter(w)
+	labelNames := getLabelNames(aggregationResults)
+	if err := csvWriter.Write(append(slices.Clone(labelNames), bytesColumn)); err != nil {
+		return err
+	}
+	for _, aggregationResult := range aggregationResults {
+		record := labelValues(labelNames, aggregationResult.AggregationGroup)
+		record = append(record, strconv.FormatInt(aggregationResult.StorageUsage, 10))
+		if err := csvWriter.Write(record); err != nil {
+			return err
+		}
+	}
+	csvWriter.Flush()
+	return csvWriter.Error()
+}
+
+func writeTable(w io.Writer, aggregationResults []agg.AggregationResult, humanReadable bool) error {
+	tabWriter := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	labelNames := getLabelNames(aggregationResults)
+	header := append(slices.Clone(labelNames), bytesColumn)
+	if _, err := fmt.Fprintln(tabWriter, strings.Join(header, "\t")); err != nil {
+		return err
+	}
+	for _, aggregationResult := range aggregationResults {
+		record := labelValues(labelNames, aggregationResult.AggregationGroup)
+		record = append(record, formatBytes(aggregationResult.StorageUsage, humanReadable))
+		if _, err := fmt.Fprintln(tabWriter, strings.Join(record, "\t")); err != nil {
+			return err
+		}
+	}
+	return tabWriter.Flush()
+}
+
+func writeMarkdown(w io.Writer, aggregationResults []agg.AggregationResult, humanReadable bool) error {
+	labelNames := getLabelNames(aggregationResults)
+	header := append(slices.Clone(labelNames), bytesColumn)
+	separator := make([]string, len(header))
+	for i := range labelNames {
+		separator[i] = "---"
+	}
+	separator[len(separator)-1] = "---:"
+	lines := []string{markdownRow(header), markdownRow(separator)}
+	for _, aggregationResult := range aggregationResults {
+		record := labelValues(labelNames, aggregationResult.AggregationGroup)
+		record = append(record, formatBytes(aggregationResult.StorageUsage, humanReadable))
+		lines = append(lines, markdownRow(record))
+	}
+	_, err := fmt.Fprintln(w, strings.Join(lines, "\n"))
+	return err
+}
+
+func markdownRow(cells []string) string {
+	escaped := make([]string, len(cells))
+	for i, cell := range cells {
+		escaped[i] = strings.ReplaceAll(cell, "|", `\|`)
+	}
+	return "| " + strings.Join(escaped, " | ") + " |"
+}
+
+// getLabelNames returns the (sorted) label names used in the results, including agg.Deleted as last one
+func getLabelNames(aggregationResults []agg.AggregationResult) []string {
+	var labelNames []string
+	for _, aggregationResult := range aggregationResults {
+		for labelName := range aggregationResult.AggregationGroup.Labels {
+			if !slices.Contains(labelNames, labelName) {
+				labelNames = append(labelNames, labelName)
+			}
+		}
+	}
+	slices.Sort(labelNames)
+	return append(labelNames, agg.Deleted)
+}
+
+func labelValues(labelNames []string, aggregationGroup agg.AggregationGroup) []string {
+	values := make([]string, len(labelNames))
+	for i, labelName := range labelNames {
+		if labelName == agg.Deleted {
+			values[i] = strconv.FormatBool(aggregationGroup.Deleted)
+		} else {
+			values[i] = aggregationGroup.Labels[labelName]
+		}
+	}
+	return values
+}
+
+func formatBytes(bytes du.StorageUsage, humanReadable bool) string {
+	if !humanReadable {
+		return strconv.FormatInt(bytes, 10)
+	}
+	return HumanReadableBytes(bytes)
+}
+
+// HumanReadableBytes formats bytes using binary (IEC) units, e.g. 1.5 GiB
+func HumanReadableBytes(bytes du.StorageUsage) string {
+	const unit = 1024
+	if bytes < unit && bytes > -unit {
+		return fmt.Sprintf("%d B", bytes)
+	}
+	value := float64(bytes)
+	exp := 0
+	for value >= unit*unit || value <= -unit*unit {
+		value /= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", value/unit, "KMGTPE"[exp])
+}
+
+// DirListing is the (stable) JSON representation of the usage of the children of a prefix
+type DirListing struct {
+	RunDate  time.Time  `json:"runDate"`
+	Prefix   string     `json:"prefix"`
+	Children []DirEntry `json:"children"`
+}
+
+// DirEntry is the (stable) JSON representation of a single du.DirUsage
+type DirEntry struct {
+	Dir          string          `json:"dir"`
+	Bytes        du.StorageUsage `json:"bytes"`
+	Count        int64           `json:"count"`
+	DeletedBytes du.StorageUsage `json:"deletedBytes"`
+	DeletedCount int64           `json:"deletedCount"`
+}
+
+func NewDirListing(runDate time.Time, prefix string, dirUsages []du.DirUsage) DirListing {
+	children := make([]DirEntry, len(dirUsages))
+	for i, dirUsage := range dirUsages {
+		children[i] = DirEntry(dirUsage)
+	}
+	return DirListing{RunDate: runDate, Prefix: prefix, Children: children}
+}
+
+// WriteDirListing renders the usage of the children of a prefix, like ncdu does
+func WriteDirListing(w io.Writer, dirListing DirListing, options Options) error {
+	children := dirListing.Children
+	if options.Top > 0 && len(children) > options.Top {
+		children = children[:options.Top]
+	}
+	header := []string{"dir", bytesColumn, "count", "deleted bytes", "deleted count"}
+	records := make([][]string, len(children))
+	for i, child := range children {
+		dir := child.Dir
+		if dir == dirListing.Prefix {
+			dir += " (itself)"
+		}
+		humanReadable := options.HumanReadable && options.Format != FormatCSV
+		records[i] = []string{
+			dir,
+			formatBytes(child.Bytes, humanReadable),
+			strconv.FormatInt(child.Count, 10),
+			formatBytes(child.DeletedBytes, humanReadable),
+			strconv.FormatInt(child.DeletedCount, 10),
+		}
+	}
+
+	if options.Format == FormatJSON {
+		dirListing.Children = children
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(dirListing)
+	}
+	return writeRecords(w, options.Format, header, []string{"---", "---:", "---:", "---:", "---:"}, records)
+}
+
+// writeRecords renders records in the csv, table or markdown format, markdownAlignment is the markdown separator row
+func writeRecords(w io.Writer, format Format, header []string, markdownAlignment []string, records [][]string) error {
+	switch format {
+	case FormatCSV:
+		csvWriter := csv.NewWriter(w)
+		if err := csvWriter.WriteAll(append([][]string{header}, records...)); err != nil {
+			return err
+		}
+		return csvWriter.Error()
+	case FormatTable:
+		tabWriter := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		for _, record := range append([][]string{header}, records...) {
+			if _, err := fmt.Fprintln(tabWriter, strings.Join(record, "\t")); err != nil {
+				return err
+			}
+		}
+		return tabWriter.Flush()
+	case FormatMarkdown:
+		lines := []string{markdownRow(header), markdownRow(markdownAlignment)}
+		for _, record := range records {
+			lines = append(lines, markdownRow(record))
+		}
+		_, err := fmt.Fprintln(w, strings.Join(lines, "\n"))
+		return err
+	case FormatJSON:
+	}
+	return errors.New("unknown format: " + string(format))
+}
+
+// Coverage is the (stable) JSON representation of agg.Coverage
+type Coverage struct {
+	RunDate   time.Time           `json:"runDate"`
+	Rules     []RuleCoverageEntry `json:"rules"`
+	Unmatched []DuRowEntry        `json:"unmatched"`
+	Excluded  ExcludedEntry       `json:"excluded"`
+}
+
+// ExcludedEntry is the (stable) JSON representation of agg.ExcludedUsage
+type ExcludedEntry struct {
+	Bytes        du.StorageUsage `json:"bytes"`
+	Count        int64           `json:"count"`
+	DeletedBytes du.StorageUsage `json:"deletedBytes"`
+	DeletedCount int64           `json:"deletedCount"`
+}
+
+// RuleCoverageEntry is the (stable) JSON representation of agg.RuleCoverage
+type RuleCoverageEntry struct {
+	Rule   string          `json:"rule"`
+	Bytes  du.StorageUsage `json:"bytes"`
+	DuRows int64           `json:"duRows"`
+}
+
+// DuRowEntry is the (stable) JSON representation of du.Row
+type DuRowEntry struct {
+	Dir     string          `json:"dir"`
+	Deleted bool            `json:"deleted"`
+	Bytes   du.StorageUsage `json:"bytes"`
+	Count   int64           `json:"count"`
+}
+
+func NewCoverage(runDate time.Time, coverage agg.Coverage) Coverage {
+	rules := make([]RuleCoverageEntry, len(coverage.Rules))
+	for i, ruleCoverage := range coverage.Rules {
+		rules[i] = RuleCoverageEntry(ruleCoverage)
+	}
+	return Coverage{RunDate: runDate, Rules: rules, Unmatched: NewDuRowEntries(coverage.Unmatched), Excluded: ExcludedEntry(coverage.Excluded)}
+}
+
+func NewDuRowEntries(rows []du.Row) []DuRowEntry {
+	entries := make([]DuRowEntry, len(rows))
+	for i, row := range rows {
+		entries[i] = DuRowEntry{Dir: row.Dir, Deleted: row.Deleted != nil && *row.Deleted, Bytes: row.Bytes, Count: row.Count}
+	}
+	return entries
+}
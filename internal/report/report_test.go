@@ -0,0 +1,72 @@
+package report
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/PDOK/azure-storage-usage-exporter/internal/agg"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWrite(t *testing.T) {
+	someFixedTime, _ := time.Parse(time.DateOnly, "2024-04-20")
+	aggregationResults := []agg.AggregationResult{
+		{AggregationGroup: agg.AggregationGroup{Labels: agg.Labels{"tenant": "a", "type": "x"}, Deleted: false}, StorageUsage: 1536},
+		{AggregationGroup: agg.AggregationGroup{Labels: agg.Labels{"tenant": "b", "type": "y"}, Deleted: true}, StorageUsage: 5 << 30},
+		{AggregationGroup: agg.AggregationGroup{Labels: agg.Labels{"tenant": "c|d", "type": "z"}, Deleted: false}, StorageUsage: 12},
+	}
+	tests := []struct {
+		name    string
+		options Options
+		want    string
+	}{{
+		name:    "table",
+		options: Options{Format: FormatTable, HumanReadable: true},
+		want: `tenant  type  deleted  bytes
+b       y     true     5.0 GiB
+a       x     false    1.5 KiB
+c|d     z     false    12 B
+`,
+	}, {
+		name:    "csv top 2",
+		options: Options{Format: FormatCSV, Top: 2, HumanReadable: true},
+		want: `tenant,type,deleted,bytes
+b,y,true,5368709120
+a,x,false,1536
+`,
+	}, {
+		name:    "markdown",
+		options: Options{Format: FormatMarkdown, Top: 1},
+		want: `| tenant | type | deleted | bytes |
+| --- | --- | --- | ---: |
+| b | y | true | 5368709120 |
+`,
+	}, {
+		name:    "json",
+		options: Options{Format: FormatJSON, Top: 1},
+		want: `{
+  "runDate": "2024-04-20T00:00:00Z",
+  "results": [
+    {
+      "labels": {
+        "tenant": "b",
+        "type": "y"
+      },
+      "deleted": true,
+      "bytes": 5368709120
+    }
+  ]
+}
+`,
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buf := new(bytes.Buffer)
+			err := Write(buf, someFixedTime, aggregationResults, tt.options)
+			require.Nil(t, err)
+			assert.Equal(t, tt.want, buf.String())
+		})
+	}
+}
@@ -0,0 +1,62 @@
+package report
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/PDOK/azure-storage-usage-exporter/internal/agg"
+	"github.com/PDOK/azure-storage-usage-exporter/internal/du"
+	"gopkg.in/yaml.v2"
+)
+
+// suggestedConfig is the part of the config file that a agg.RuleSuggestion is about
+type suggestedConfig struct {
+	Labels agg.Labels      `yaml:"labels"`
+	Rules  []suggestedRule `yaml:"rules"`
+}
+
+type suggestedRule struct {
+	Pattern string `yaml:"pattern"`
+}
+
+// WriteRuleSuggestion renders the suggestion as config file YAML, with the profile of each depth as comments
+func WriteRuleSuggestion(w io.Writer, runDate time.Time, suggestion agg.RuleSuggestion) error {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "# suggested from the dirs of run %s (%s in total), refine as needed\n#\n",
+		runDate.Format(du.RunDateFormat), HumanReadableBytes(suggestion.Total))
+	tw := tabwriter.NewWriter(&sb, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "# depth\tnames\tdirs\tbytes this deep\tsuggestion\tlargest names")
+	for _, depth := range suggestion.Depths {
+		outcome := depth.Reason
+		switch {
+		case depth.Label != "":
+			outcome = "label " + depth.Label
+		case depth.Literal != "":
+			outcome = "always " + depth.Literal
+		}
+		largest := make([]string, len(depth.Largest))
+		for i, name := range depth.Largest {
+			largest[i] = fmt.Sprintf("%s (%s)", name.Name, HumanReadableBytes(name.Bytes))
+		}
+		fmt.Fprintf(tw, "# %d\t%d\t%d\t%.1f%%\t%s\t%s\n", depth.Depth, depth.Distinct, depth.DistinctPrefixes,
+			100*float64(depth.Bytes)/float64(max(suggestion.Total, 1)), outcome, strings.Join(largest, ", "))
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	config := suggestedConfig{Labels: suggestion.Labels, Rules: make([]suggestedRule, len(suggestion.Rules))}
+	for i, rule := range suggestion.Rules {
+		config.Rules[i] = suggestedRule{Pattern: rule.String()}
+	}
+	configYaml, err := yaml.Marshal(config)
+	if err != nil {
+		return err
+	}
+	sb.WriteString("\n")
+	sb.Write(configYaml)
+	_, err = io.WriteString(w, sb.String())
+	return err
+}